@@ -5,13 +5,45 @@ import (
 )
 
 type Config struct {
-	APIKeyPath       stepconf.Secret `env:"api_key_path"`
-	APIIssuer        string          `env:"api_issuer"`
-	BuildAPIToken    string          `env:"build_api_token"`
-	BuildURL         string          `env:"build_url"`
-	DeviceName       string          `env:"device_name"`
-	DeviceUDID       string          `env:"device_udid"`
-	DevicePlatform   string          `env:"device_platform"`
-	XcarchivePath    string          `env:"xcarchive_path"`
-	BundleIDToExport string          `env:"bundle_id_to_export"`
+	APIKeyPath                       stepconf.Secret `env:"api_key_path"`
+	APIIssuer                        string          `env:"api_issuer"`
+	BuildAPIToken                    string          `env:"build_api_token"`
+	BuildURL                         string          `env:"build_url"`
+	DeviceName                       string          `env:"device_name"`
+	DeviceUDID                       string          `env:"device_udid"`
+	DevicePlatform                   string          `env:"device_platform"`
+	DeviceModel                      string          `env:"device_model"`
+	DevicesFile                      string          `env:"devices_file"`
+	RegisterTestDevices              bool            `env:"register_test_devices,opt[yes,no]"`
+	RenameExisting                   bool            `env:"rename_existing,opt[yes,no]"`
+	DeviceLimitPolicy                string          `env:"device_limit_policy,opt[fail,warn]"`
+	FailOn                           string          `env:"fail_on,opt[any,all,none]"`
+	Concurrency                      int             `env:"concurrency,range[1..20]"`
+	Mode                             string          `env:"mode,opt[register,decommission]"`
+	DecommissionUDIDs                string          `env:"decommission_udids"`
+	InventoryFile                    string          `env:"inventory_file"`
+	MaxDecommission                  int             `env:"max_decommission,range[1..100]"`
+	DecommissionRegenerateProfiles   bool            `env:"decommission_regenerate_profiles,opt[yes,no]"`
+	DryRun                           bool            `env:"dry_run,opt[yes,no]"`
+	ProfileJournalPath               string          `env:"profile_journal_path,required"`
+	ProfileDeviceMembership          string          `env:"profile_device_membership,opt[all,existing-plus-new,explicit]"`
+	ProfileDeviceUDIDs               string          `env:"profile_device_udids"`
+	AdHocForDistributionProfiles     bool            `env:"adhoc_for_distribution_profiles,opt[yes,no]"`
+	MinProfileDaysValid              int             `env:"min_profile_days_valid,range[0..365]"`
+	XcarchivePath                    string          `env:"xcarchive_path"`
+	BundleIDToExport                 string          `env:"bundle_id_to_export"`
+	ExportSigningStyle               string          `env:"export_signing_style,opt[manual,automatic]"`
+	ExportCompileBitcode             bool            `env:"export_compile_bitcode,opt[yes,no]"`
+	ExportThinning                   string          `env:"export_thinning,required"`
+	ExportStripSwiftSymbols          bool            `env:"export_strip_swift_symbols,opt[yes,no]"`
+	ExportUploadSymbols              bool            `env:"export_upload_symbols,opt[yes,no]"`
+	ExportICloudContainerEnvironment string          `env:"export_icloud_container_environment"`
+	ExportManifestAppURL             string          `env:"export_manifest_app_url"`
+	ExportManifestDisplayImageURL    string          `env:"export_manifest_display_image_url"`
+	ExportManifestFullSizeImageURL   string          `env:"export_manifest_full_size_image_url"`
+	OTABaseURL                       string          `env:"ota_base_url"`
+	OTADisplayName                   string          `env:"ota_display_name"`
+	DeployDir                        string          `env:"deploy_dir"`
+	ExportOptionsPath                string          `env:"export_options_path"`
+	ReportPath                       string          `env:"report_path"`
 }
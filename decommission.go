@@ -0,0 +1,229 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/birmacher/steps-register-ios-device/device"
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-io/go-xcode/profileutil"
+	"github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/appstoreconnect"
+)
+
+// decommissionProfileTypes lists the profile types containing devices
+var decommissionProfileTypes = []appstoreconnect.ProfileType{
+	appstoreconnect.IOSAppDevelopment,
+	appstoreconnect.IOSAppAdHoc,
+	appstoreconnect.TvOSAppDevelopment,
+	appstoreconnect.TvOSAppAdHoc,
+	appstoreconnect.MacAppDevelopment,
+}
+
+func splitUDIDs(list string) []string {
+	var udids []string
+	for _, udid := range strings.FieldsFunc(list, func(r rune) bool {
+		return r == '\n' || r == ',' || r == '|'
+	}) {
+		if udid = strings.TrimSpace(udid); udid != "" {
+			udids = append(udids, udid)
+		}
+	}
+	return udids
+}
+
+func collectDevicesToDecommission(config Config, index *device.DeviceIndex) ([]appstoreconnect.Device, error) {
+	var devices []appstoreconnect.Device
+
+	if config.DecommissionUDIDs != "" {
+		enabled, notFound, alreadyDisabled := device.FindDevicesToDecommission(index, splitUDIDs(config.DecommissionUDIDs))
+		for _, udid := range notFound {
+			log.Warnf("Device %s is not registered on App Store Connect, skipping", udid)
+		}
+		for _, ascDevice := range alreadyDisabled {
+			log.Warnf("Device %s (%s) is already disabled, skipping", ascDevice.Attributes.Name, ascDevice.Attributes.UDID)
+		}
+		devices = append(devices, enabled...)
+	}
+
+	if config.InventoryFile != "" {
+		inventory, err := device.ParseDevicesFile(config.InventoryFile, config.DevicePlatform)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("%d device(s) found in inventory file: %s", len(inventory), config.InventoryFile)
+
+		for _, retired := range device.FindRetiredDevices(index, inventory) {
+			if !containsDevice(devices, retired) {
+				devices = append(devices, retired)
+			}
+		}
+	}
+
+	if config.DecommissionUDIDs == "" && config.InventoryFile == "" {
+		return nil, fmt.Errorf("No device to decommission: provide decommission_udids or inventory_file")
+	}
+
+	return devices, nil
+}
+
+func containsDevice(devices []appstoreconnect.Device, ascDevice appstoreconnect.Device) bool {
+	for _, d := range devices {
+		if d.ID == ascDevice.ID {
+			return true
+		}
+	}
+	return false
+}
+
+func runDecommission(client *appstoreconnect.Client, journal *Journal, config Config) error {
+	log.Printf("")
+	log.Infof("Decommissioning devices")
+
+	registeredDevices, err := device.ListAllDevices(client)
+	if err != nil {
+		return err
+	}
+
+	devices, err := collectDevicesToDecommission(config, device.NewDeviceIndex(registeredDevices))
+	if err != nil {
+		return err
+	}
+
+	if len(devices) == 0 {
+		log.Donef("No device to decommission")
+		return nil
+	}
+
+	log.Printf("%d device(s) to disable:", len(devices))
+	for _, ascDevice := range devices {
+		log.Printf("- %s (%s), %s", ascDevice.Attributes.Name, ascDevice.Attributes.UDID, ascDevice.Attributes.DeviceClass)
+	}
+
+	if len(devices) > config.MaxDecommission {
+		return fmt.Errorf("Refusing to disable %d devices, at most %d device(s) can be disabled in a run (max_decommission input)", len(devices), config.MaxDecommission)
+	}
+
+	deviceIDs := map[string]bool{}
+	for _, ascDevice := range devices {
+		deviceIDs[ascDevice.ID] = true
+	}
+
+	if config.DryRun {
+		log.Warnf("Dry run: devices are not disabled")
+		for _, ascDevice := range devices {
+			runReport.Devices = append(runReport.Devices, decommissionResult(ascDevice, nil, true))
+		}
+	} else {
+		for _, ascDevice := range devices {
+			err := device.DisableDevice(client, ascDevice)
+			runReport.Devices = append(runReport.Devices, decommissionResult(ascDevice, err, false))
+			if err != nil {
+				return err
+			}
+			log.Donef("Device %s (%s) successfully disabled", ascDevice.Attributes.Name, ascDevice.Attributes.UDID)
+		}
+	}
+
+	if !config.DecommissionRegenerateProfiles {
+		return nil
+	}
+
+	return regenerateProfilesWithoutDevices(client, journal, deviceIDs, config.DryRun)
+}
+
+// decommissionResult returns the outcome of disabling the device, recorded in the run report
+func decommissionResult(ascDevice appstoreconnect.Device, err error, dryRun bool) device.Result {
+	platform := "ios"
+	if ascDevice.Attributes.Platform == appstoreconnect.MacOS {
+		platform = "macos"
+	}
+
+	result := device.Result{
+		Device: device.Device{
+			Name:     ascDevice.Attributes.Name,
+			UDID:     ascDevice.Attributes.UDID,
+			Platform: platform,
+		},
+		Status:   device.StatusDisabled,
+		PortalID: ascDevice.ID,
+		DryRun:   dryRun,
+	}
+	if err != nil {
+		result.Status = device.StatusFailed
+		result.Error = err
+	}
+	return result
+}
+
+// regenerateProfilesWithoutDevices recreates the development and ad-hoc profiles including any of the given devices,
+// so that they stop including them
+func regenerateProfilesWithoutDevices(client *appstoreconnect.Client, journal *Journal, deviceIDs map[string]bool, dryRun bool) error {
+	log.Printf("")
+	log.Infof("Regenerating provisioning profiles including the disabled devices")
+
+	for _, profileType := range decommissionProfileTypes {
+		profiles, err := ListProfilesWithType(client, profileType)
+		if err != nil {
+			return fmt.Errorf("Failed to list %s provisioning profiles\n%v", profileType, err)
+		}
+
+		for _, profile := range profiles {
+			profile := profile
+
+			if profileutil.IsXcodeManaged(profile.Attributes.Name) {
+				continue
+			}
+
+			devicesInProfile, err := GetDevices(client, &profile)
+			if err != nil {
+				return err
+			}
+
+			var remainingDeviceIDs []string
+			affected := false
+			for _, deviceInProfile := range devicesInProfile {
+				if deviceIDs[deviceInProfile.ID] {
+					affected = true
+					continue
+				}
+				remainingDeviceIDs = append(remainingDeviceIDs, deviceInProfile.ID)
+			}
+			if !affected {
+				continue
+			}
+			if len(remainingDeviceIDs) == 0 {
+				log.Warnf("Provisioning profile %s (%s) would not include any device, skipping", profile.Attributes.Name, profile.Attributes.UUID)
+				continue
+			}
+
+			regeneration, err := NewProfileRegeneration(client, &profile, remainingDeviceIDs)
+			if err != nil {
+				return err
+			}
+
+			if dryRun {
+				log.Printf("Dry run: provisioning profile %s (%s) would be recreated with %d device(s)", profile.Attributes.Name, profile.Attributes.UUID, len(remainingDeviceIDs))
+				if runReport.Plan == nil {
+					runReport.Plan = &Plan{}
+				}
+				runReport.Plan.Profiles = append(runReport.Plan.Profiles, NewProfilePlan(*regeneration, nil))
+				continue
+			}
+
+			log.Printf("Attempting to update provisioning profile on Apple Developer Portal: %s", profile.Attributes.Name)
+			newProfile, err := regeneration.Execute(client, journal)
+			if err != nil {
+				return err
+			}
+			log.Donef("Provisioning profile %s (%s) successfully created on Apple Deveper Portal", newProfile.Attributes.Name, newProfile.Attributes.UUID)
+
+			profileReport, err := NewProfileReport(client, regeneration.BundleID.Attributes.Identifier, profile.Attributes.UUID, ProfileRegenerated, *newProfile, "")
+			if err != nil {
+				return err
+			}
+			runReport.Profiles = append(runReport.Profiles, profileReport)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,65 @@
+package device
+
+import (
+	"fmt"
+
+	"github.com/bitrise-steplib/steps-deploy-to-itunesconnect-deliver/devportalservice"
+	"github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/appstoreconnect"
+)
+
+// DisableDevice disables a registered device on the Developer Portal with a PATCH request on devices/{id}
+func DisableDevice(client *appstoreconnect.Client, ascDevice appstoreconnect.Device) error {
+	_, err := modifyDevice(client, ascDevice.ID, DeviceUpdateRequestDataAttributes{
+		Status: appstoreconnect.Disabled,
+	})
+	if err != nil {
+		return ascError(fmt.Sprintf("Failed to disable device %s (%s)", ascDevice.Attributes.Name, ascDevice.Attributes.UDID), err)
+	}
+
+	return nil
+}
+
+// FindDevicesToDecommission returns the enabled registered devices with the given UDIDs.
+// UDIDs not registered or already disabled are returned separately.
+func FindDevicesToDecommission(index *DeviceIndex, udids []string) (enabled []appstoreconnect.Device, notFound []string, alreadyDisabled []appstoreconnect.Device) {
+	for _, udid := range udids {
+		ascDevices := index.Lookup(udid)
+		if len(ascDevices) == 0 {
+			notFound = append(notFound, udid)
+			continue
+		}
+
+		for _, ascDevice := range ascDevices {
+			if ascDevice.Attributes.Status == appstoreconnect.Disabled {
+				alreadyDisabled = append(alreadyDisabled, ascDevice)
+				continue
+			}
+			enabled = append(enabled, ascDevice)
+		}
+	}
+
+	return enabled, notFound, alreadyDisabled
+}
+
+// FindRetiredDevices returns the enabled registered devices absent from the inventory
+func FindRetiredDevices(index *DeviceIndex, inventory []Device) []appstoreconnect.Device {
+	var retired []appstoreconnect.Device
+	for _, ascDevice := range index.Devices() {
+		if ascDevice.Attributes.Status == appstoreconnect.Disabled {
+			continue
+		}
+
+		found := false
+		for _, device := range inventory {
+			if devportalservice.IsEqualUDID(device.UDID, ascDevice.Attributes.UDID) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			retired = append(retired, ascDevice)
+		}
+	}
+
+	return retired
+}
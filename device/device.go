@@ -1,8 +1,10 @@
 package device
 
 import (
+	"fmt"
 	"strings"
 
+	"github.com/bitrise-steplib/steps-deploy-to-itunesconnect-deliver/devportalservice"
 	"github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/appstoreconnect"
 )
 
@@ -10,13 +12,15 @@ type Device struct {
 	Name     string
 	UDID     string
 	Platform string
+	// Model is the optional model identifier of the device, like iPhone13,4
+	Model string
 }
 
 func (d Device) ASCPlatform() appstoreconnect.BundleIDPlatform {
 	switch strings.ToLower(d.Platform) {
 	case "ios":
 		return appstoreconnect.IOS
-	case "macos":
+	case "macos", "mac":
 		return appstoreconnect.MacOS
 	case "universal":
 		return appstoreconnect.Universal
@@ -24,3 +28,82 @@ func (d Device) ASCPlatform() appstoreconnect.BundleIDPlatform {
 
 	return appstoreconnect.BundleIDPlatform("UNKNOWN")
 }
+
+// Validate checks that the device has every attribute required for the registration
+func (d Device) Validate() error {
+	if d.UDID == "" {
+		return fmt.Errorf("Device UDID not provided for device: %s", d.Name)
+	}
+	if _, _, err := ParseUDID(d.UDID); err != nil {
+		return fmt.Errorf("Invalid UDID for device %s: %v", d.Name, err)
+	}
+	if d.Name == "" {
+		return fmt.Errorf("Device name not provided for device: %s", d.UDID)
+	}
+	if d.Model != "" {
+		if _, err := ClassForModel(d.Model); err != nil {
+			return fmt.Errorf("Invalid model for device %s (%s): %v", d.Name, d.UDID, err)
+		}
+	}
+	if d.Platform == "" {
+		return fmt.Errorf("Device platform not provided and can not be inferred for device %s (%s)", d.Name, d.UDID)
+	}
+	if d.ASCPlatform() == appstoreconnect.BundleIDPlatform("UNKNOWN") {
+		return fmt.Errorf("Unsupported platform (%s) for device %s (%s)", d.Platform, d.Name, d.UDID)
+	}
+	if err := d.checkConsistency(); err != nil {
+		return fmt.Errorf("Invalid device %s: %v", d.Name, err)
+	}
+
+	return nil
+}
+
+// Normalize returns the validated device with whitespace and stray characters stripped from its UDID.
+// If the platform is not provided, it is inferred from the model identifier or the UDID format,
+// a modern UDID without a model identifier is registered as an iOS device.
+// Only an explicitly provided platform is checked against the model identifier and the UDID format.
+func (d Device) Normalize() (Device, error) {
+	if d.Platform == "" {
+		d.Platform = d.InferredPlatform()
+	}
+	if d.Platform == "" {
+		d.Platform = "ios"
+	}
+	if err := d.Validate(); err != nil {
+		return Device{}, err
+	}
+
+	udid, _, err := ParseUDID(d.UDID)
+	if err != nil {
+		return Device{}, err
+	}
+	d.UDID = udid
+
+	return d, nil
+}
+
+// Deduplicate returns the devices with the repeated UDIDs removed, keeping the first occurrence.
+// UDIDs are compared case-insensitively with the '-' separator ignored.
+func Deduplicate(devices []Device) (unique []Device, duplicated []Device) {
+	for _, device := range devices {
+		if FindDevice(unique, device.UDID) != nil {
+			duplicated = append(duplicated, device)
+			continue
+		}
+
+		unique = append(unique, device)
+	}
+
+	return unique, duplicated
+}
+
+// FindDevice returns the device with the given UDID, compared case-insensitively with the '-' separator ignored
+func FindDevice(devices []Device, udid string) *Device {
+	for i, device := range devices {
+		if devportalservice.IsEqualUDID(device.UDID, udid) {
+			return &devices[i]
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,141 @@
+package device
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+type fileDevice struct {
+	UDID     string `json:"udid"`
+	Name     string `json:"name"`
+	Platform string `json:"platform"`
+	Model    string `json:"model"`
+
+	// location is the line of the row in a delimited file, or the position of the entry in a JSON file, used in errors
+	location string
+}
+
+// ParseDevicesFile reads the devices listed in the file at the given path.
+// Supported formats are Apple's bulk upload format (tab-delimited `Device ID`, `Device Name`, `Device Platform` columns),
+// CSV with the same columns and a JSON array of `{"udid", "name", "platform", "model"}` objects.
+// An optional fourth `Device Model` column holds the model identifier, like iPhone13,4.
+// Rows without a platform use the platform inferred from the model identifier or the UDID format,
+// and fall back to the provided default platform.
+// Every UDID is validated, an invalid UDID fails the parsing with the line of the row.
+func ParseDevicesFile(pth string, defaultPlatform string) ([]Device, error) {
+	content, err := ioutil.ReadFile(pth)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read devices file: %s\n%v", pth, err)
+	}
+
+	var rows []fileDevice
+	switch {
+	case strings.ToLower(filepath.Ext(pth)) == ".json" || bytes.HasPrefix(bytes.TrimSpace(content), []byte("[")):
+		if err := json.Unmarshal(content, &rows); err != nil {
+			return nil, fmt.Errorf("Failed to parse devices file as JSON: %s\n%v", pth, err)
+		}
+		for i := range rows {
+			rows[i].location = fmt.Sprintf("entry %d", i+1)
+		}
+	case strings.ToLower(filepath.Ext(pth)) == ".csv":
+		rows, err = parseDelimitedDevices(content, ',')
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse devices file as CSV: %s\n%v", pth, err)
+		}
+	default:
+		rows, err = parseDelimitedDevices(content, '\t')
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse devices file as tab-delimited: %s\n%v", pth, err)
+		}
+	}
+
+	var devices []Device
+	for _, row := range rows {
+		udid, _, err := ParseUDID(row.UDID)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid device in devices file: %s, %s\n%v", pth, row.location, err)
+		}
+
+		device := Device{
+			Name:     strings.TrimSpace(row.Name),
+			UDID:     udid,
+			Platform: strings.TrimSpace(row.Platform),
+			Model:    strings.TrimSpace(row.Model),
+		}
+		if device.Platform == "" {
+			device.Platform = device.InferredPlatform()
+		}
+		if device.Platform == "" {
+			device.Platform = defaultPlatform
+		}
+
+		devices = append(devices, device)
+	}
+
+	return devices, nil
+}
+
+// parseDelimitedDevices parses the file line by line, so that the errors can refer to the line of the row
+func parseDelimitedDevices(content []byte, delimiter rune) ([]fileDevice, error) {
+	var rows []fileDevice
+	headerChecked := false
+	for i, line := range strings.Split(string(content), "\n") {
+		lineNumber := i + 1
+		line = strings.TrimSuffix(line, "\r")
+		if strings.TrimSpace(strings.TrimPrefix(line, "\ufeff")) == "" {
+			continue
+		}
+
+		reader := csv.NewReader(strings.NewReader(line))
+		reader.Comma = delimiter
+		reader.FieldsPerRecord = -1
+		reader.LazyQuotes = true
+		// Trimming the leading tabs would merge the empty columns of a tab-delimited row, the values are trimmed later
+		reader.TrimLeadingSpace = delimiter != '\t'
+
+		record, err := reader.Read()
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNumber, err)
+		}
+
+		if !headerChecked {
+			headerChecked = true
+			if isDevicesFileHeader(record) {
+				continue
+			}
+		}
+		if len(record) < 2 {
+			return nil, fmt.Errorf("line %d has %d column(s), expected at least Device ID and Device Name", lineNumber, len(record))
+		}
+
+		row := fileDevice{
+			UDID:     record[0],
+			Name:     record[1],
+			location: fmt.Sprintf("line %d", lineNumber),
+		}
+		if len(record) > 2 {
+			row.Platform = record[2]
+		}
+		if len(record) > 3 {
+			row.Model = record[3]
+		}
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+func isDevicesFileHeader(record []string) bool {
+	if len(record) == 0 {
+		return false
+	}
+
+	// Apple's template may start with a UTF-8 BOM
+	first := strings.ToLower(strings.TrimSpace(strings.TrimPrefix(record[0], "\ufeff")))
+	return first == "device id" || first == "udid"
+}
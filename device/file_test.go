@@ -0,0 +1,81 @@
+package device
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseDevicesFile(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		want []Device
+	}{
+		{
+			name: "tab-delimited with BOM and header",
+			file: "devices.txt",
+			want: []Device{
+				{Name: "Legacy iPhone", UDID: "0123456789abcdef0123456789abcdef01234567", Platform: "ios"},
+				{Name: "Modern iPhone", UDID: "00008030-001A2B3C4D5E6F70", Platform: "ios", Model: "iPhone13,4"},
+				{Name: "Intel Mac", UDID: "A1B2C3D4-E5F6-A7B8-C9D0-E1F2A3B4C5D6", Platform: "macos"},
+			},
+		},
+		{
+			name: "CSV with header",
+			file: "devices.csv",
+			want: []Device{
+				{Name: "Legacy iPhone", UDID: "0123456789abcdef0123456789abcdef01234567", Platform: "ios"},
+				{Name: "Modern iPhone", UDID: "00008030-001A2B3C4D5E6F70", Platform: "ios", Model: "iPhone13,4"},
+			},
+		},
+		{
+			name: "CSV without header, default platform",
+			file: "no_header.csv",
+			want: []Device{
+				{Name: "Modern iPhone", UDID: "00008030-001A2B3C4D5E6F70", Platform: "tvos"},
+			},
+		},
+		{
+			name: "JSON",
+			file: "devices.json",
+			want: []Device{
+				{Name: "Legacy iPhone", UDID: "0123456789abcdef0123456789abcdef01234567", Platform: "ios"},
+				{Name: "Modern iPhone", UDID: "00008030-001A2B3C4D5E6F70", Platform: "ios", Model: "iPhone13,4"},
+				{Name: "Intel Mac", UDID: "A1B2C3D4-E5F6-A7B8-C9D0-E1F2A3B4C5D6", Platform: "macos"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseDevicesFile(filepath.Join("testdata", tt.file), "tvos")
+			if err != nil {
+				t.Fatalf("ParseDevicesFile() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseDevicesFile() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseDevicesFile_Errors(t *testing.T) {
+	tests := []struct {
+		file    string
+		wantErr string
+	}{
+		{file: "invalid_udid.txt", wantErr: "line 4"},
+		{file: "invalid_udid.json", wantErr: "entry 2"},
+		{file: "missing_name.txt", wantErr: "line 2 has 1 column(s)"},
+		{file: "missing.txt", wantErr: "Failed to read devices file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			_, err := ParseDevicesFile(filepath.Join("testdata", tt.file), "ios")
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ParseDevicesFile() error = %v, want error containing %s", err, tt.wantErr)
+			}
+		})
+	}
+}
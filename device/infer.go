@@ -0,0 +1,108 @@
+package device
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/appstoreconnect"
+)
+
+var modelIdentifierRegexp = regexp.MustCompile(`^([A-Za-z]+)\d+,\d+$`)
+
+// modelClasses maps the model identifier prefixes (e.g. iPhone in iPhone13,4) to device classes
+var modelClasses = map[string]appstoreconnect.DeviceClass{
+	"iphone":     appstoreconnect.Iphone,
+	"ipad":       appstoreconnect.Ipad,
+	"ipod":       appstoreconnect.Ipod,
+	"watch":      appstoreconnect.AppleWatch,
+	"appletv":    appstoreconnect.AppleTV,
+	"mac":        appstoreconnect.Mac,
+	"imac":       appstoreconnect.Mac,
+	"imacpro":    appstoreconnect.Mac,
+	"macbook":    appstoreconnect.Mac,
+	"macbookair": appstoreconnect.Mac,
+	"macbookpro": appstoreconnect.Mac,
+	"macmini":    appstoreconnect.Mac,
+	"macpro":     appstoreconnect.Mac,
+}
+
+// ClassForModel returns the device class of a model identifier, like iPhone13,4, Watch6,1, AppleTV11,1 or MacBookPro18,1
+func ClassForModel(model string) (appstoreconnect.DeviceClass, error) {
+	match := modelIdentifierRegexp.FindStringSubmatch(strings.TrimSpace(model))
+	if match == nil {
+		return "", fmt.Errorf("Invalid model identifier (%s), expected a format like iPhone13,4", model)
+	}
+
+	class, ok := modelClasses[strings.ToLower(match[1])]
+	if !ok {
+		return "", fmt.Errorf("Unknown model identifier (%s)", model)
+	}
+
+	return class, nil
+}
+
+func platformForClass(class appstoreconnect.DeviceClass) string {
+	if class == appstoreconnect.Mac {
+		return "macos"
+	}
+	return "ios"
+}
+
+// InferredPlatform returns the platform derived from the model identifier or the UDID format,
+// or an empty string if it can not be inferred
+func (d Device) InferredPlatform() string {
+	if d.Model != "" {
+		if class, err := ClassForModel(d.Model); err == nil {
+			return platformForClass(class)
+		}
+	}
+
+	if _, format, err := ParseUDID(d.UDID); err == nil {
+		switch format {
+		case MacProvisioningUDID:
+			return "macos"
+		case LegacyUDID:
+			return "ios"
+		}
+	}
+
+	return ""
+}
+
+// checkConsistency returns an error if the platform, the model identifier and the UDID format contradict each other
+func (d Device) checkConsistency() error {
+	platform := d.ASCPlatform()
+
+	_, format, err := ParseUDID(d.UDID)
+	if err != nil {
+		return err
+	}
+
+	if d.Model != "" {
+		class, err := ClassForModel(d.Model)
+		if err != nil {
+			return err
+		}
+
+		if format == MacProvisioningUDID && class != appstoreconnect.Mac {
+			return fmt.Errorf("Model %s (%s) contradicts the Mac provisioning UDID (%s)", d.Model, class, d.UDID)
+		}
+		if format == LegacyUDID && class == appstoreconnect.Mac {
+			return fmt.Errorf("Model %s (%s) contradicts the legacy iOS UDID (%s)", d.Model, class, d.UDID)
+		}
+
+		if expected := platformForClass(class); platform != appstoreconnect.Universal && (Device{Platform: expected}).ASCPlatform() != platform {
+			return fmt.Errorf("Model %s (%s) contradicts the platform (%s)", d.Model, class, d.Platform)
+		}
+	}
+
+	switch {
+	case format == MacProvisioningUDID && platform == appstoreconnect.IOS:
+		return fmt.Errorf("Mac provisioning UDID (%s) contradicts the platform (%s)", d.UDID, d.Platform)
+	case format == LegacyUDID && platform == appstoreconnect.MacOS:
+		return fmt.Errorf("Legacy iOS UDID (%s) contradicts the platform (%s)", d.UDID, d.Platform)
+	}
+
+	return nil
+}
@@ -0,0 +1,191 @@
+package device
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/appstoreconnect"
+)
+
+const (
+	legacyUDID = "0123456789abcdef0123456789abcdef01234567"
+	modernUDID = "00008030-001A2B3C4D5E6F70"
+	macUDID    = "A1B2C3D4-E5F6-A7B8-C9D0-E1F2A3B4C5D6"
+)
+
+func TestClassForModel(t *testing.T) {
+	tests := []struct {
+		model   string
+		want    appstoreconnect.DeviceClass
+		wantErr string
+	}{
+		{model: "iPhone13,4", want: appstoreconnect.Iphone},
+		{model: "iPad8,1", want: appstoreconnect.Ipad},
+		{model: "iPod9,1", want: appstoreconnect.Ipod},
+		{model: "Watch6,1", want: appstoreconnect.AppleWatch},
+		{model: "AppleTV11,1", want: appstoreconnect.AppleTV},
+		{model: "MacBookPro18,1", want: appstoreconnect.Mac},
+		{model: "MacBookAir10,1", want: appstoreconnect.Mac},
+		{model: "Macmini9,1", want: appstoreconnect.Mac},
+		{model: "iMac21,1", want: appstoreconnect.Mac},
+		{model: "Mac14,3", want: appstoreconnect.Mac},
+		{model: " iphone13,4 ", want: appstoreconnect.Iphone},
+		{model: "Vision1,1", wantErr: "Unknown model identifier"},
+		{model: "iPhone", wantErr: "Invalid model identifier"},
+		{model: "iPhone13", wantErr: "Invalid model identifier"},
+		{model: "13,4", wantErr: "Invalid model identifier"},
+		{model: "iPhone 13,4", wantErr: "Invalid model identifier"},
+		{model: "", wantErr: "Invalid model identifier"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.model, func(t *testing.T) {
+			got, err := ClassForModel(tt.model)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ClassForModel() error = %v, want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ClassForModel() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("ClassForModel() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDevice_InferredPlatform(t *testing.T) {
+	tests := []struct {
+		name   string
+		device Device
+		want   string
+	}{
+		{name: "iPhone model", device: Device{UDID: modernUDID, Model: "iPhone13,4"}, want: "ios"},
+		{name: "Apple TV model", device: Device{UDID: modernUDID, Model: "AppleTV11,1"}, want: "ios"},
+		{name: "Mac model", device: Device{UDID: modernUDID, Model: "MacBookPro18,1"}, want: "macos"},
+		{name: "Mac provisioning UDID", device: Device{UDID: macUDID}, want: "macos"},
+		{name: "legacy UDID", device: Device{UDID: legacyUDID}, want: "ios"},
+		{name: "modern UDID is ambiguous", device: Device{UDID: modernUDID}, want: ""},
+		{name: "model takes precedence over the UDID", device: Device{UDID: legacyUDID, Model: "MacBookPro18,1"}, want: "macos"},
+		{name: "unknown model falls back to the UDID", device: Device{UDID: macUDID, Model: "Vision1,1"}, want: "macos"},
+		{name: "invalid UDID", device: Device{UDID: "invalid"}, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.device.InferredPlatform(); got != tt.want {
+				t.Errorf("InferredPlatform() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDevice_checkConsistency(t *testing.T) {
+	tests := []struct {
+		name    string
+		device  Device
+		wantErr string
+	}{
+		{name: "iPhone on iOS", device: Device{UDID: modernUDID, Platform: "ios", Model: "iPhone13,4"}},
+		{name: "Watch on iOS", device: Device{UDID: modernUDID, Platform: "ios", Model: "Watch6,1"}},
+		{name: "Apple TV on iOS", device: Device{UDID: modernUDID, Platform: "ios", Model: "AppleTV11,1"}},
+		{name: "Apple silicon Mac on macOS", device: Device{UDID: modernUDID, Platform: "macos", Model: "MacBookPro18,1"}},
+		{name: "Intel Mac on macOS", device: Device{UDID: macUDID, Platform: "macos", Model: "MacBookPro16,1"}},
+		{name: "legacy UDID on iOS", device: Device{UDID: legacyUDID, Platform: "ios"}},
+		{name: "Mac provisioning UDID on macOS", device: Device{UDID: macUDID, Platform: "macos"}},
+		{name: "any model on universal", device: Device{UDID: modernUDID, Platform: "universal", Model: "MacBookPro18,1"}},
+		{name: "modern UDID on any platform", device: Device{UDID: modernUDID, Platform: "macos"}},
+		{
+			name:    "iPhone model with Mac provisioning UDID",
+			device:  Device{UDID: macUDID, Platform: "universal", Model: "iPhone13,4"},
+			wantErr: "contradicts the Mac provisioning UDID",
+		},
+		{
+			name:    "Mac model with legacy UDID",
+			device:  Device{UDID: legacyUDID, Platform: "universal", Model: "MacBookPro18,1"},
+			wantErr: "contradicts the legacy iOS UDID",
+		},
+		{
+			name:    "iPhone model on macOS",
+			device:  Device{UDID: modernUDID, Platform: "macos", Model: "iPhone13,4"},
+			wantErr: "contradicts the platform",
+		},
+		{
+			name:    "Mac model on iOS",
+			device:  Device{UDID: modernUDID, Platform: "ios", Model: "MacBookPro18,1"},
+			wantErr: "contradicts the platform",
+		},
+		{
+			name:    "Mac provisioning UDID on iOS",
+			device:  Device{UDID: macUDID, Platform: "ios"},
+			wantErr: "Mac provisioning UDID",
+		},
+		{
+			name:    "legacy UDID on macOS",
+			device:  Device{UDID: legacyUDID, Platform: "macos"},
+			wantErr: "Legacy iOS UDID",
+		},
+		{
+			name:    "unknown model",
+			device:  Device{UDID: modernUDID, Platform: "ios", Model: "Vision1,1"},
+			wantErr: "Unknown model identifier",
+		},
+		{
+			name:    "invalid UDID",
+			device:  Device{UDID: "00008030001A2B3C4D5E6F70", Platform: "ios"},
+			wantErr: "separators are misplaced",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.device.checkConsistency()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("checkConsistency() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("checkConsistency() error = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDevice_Normalize(t *testing.T) {
+	tests := []struct {
+		name         string
+		device       Device
+		wantPlatform string
+		wantErr      string
+	}{
+		{name: "Mac provisioning UDID without platform", device: Device{Name: "Mac", UDID: macUDID}, wantPlatform: "macos"},
+		{name: "legacy UDID without platform", device: Device{Name: "iPhone", UDID: legacyUDID}, wantPlatform: "ios"},
+		{name: "modern UDID without platform", device: Device{Name: "iPhone", UDID: modernUDID}, wantPlatform: "ios"},
+		{name: "Mac model without platform", device: Device{Name: "Mac", UDID: modernUDID, Model: "MacBookPro18,1"}, wantPlatform: "macos"},
+		{name: "explicit platform kept", device: Device{Name: "Mac", UDID: modernUDID, Platform: "universal"}, wantPlatform: "universal"},
+		{
+			name:    "explicit platform contradicting the UDID",
+			device:  Device{Name: "Mac", UDID: macUDID, Platform: "ios"},
+			wantErr: "Mac provisioning UDID",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.device.Normalize()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("Normalize() error = %v, want error containing %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Normalize() error = %v", err)
+			}
+			if got.Platform != tt.wantPlatform {
+				t.Errorf("Normalize().Platform = %s, want %s", got.Platform, tt.wantPlatform)
+			}
+		})
+	}
+}
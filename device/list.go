@@ -3,28 +3,74 @@ package device
 import (
 	"fmt"
 
+	"github.com/bitrise-steplib/steps-deploy-to-itunesconnect-deliver/devportalservice"
 	"github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/appstoreconnect"
-	"github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/autoprovision"
 )
 
-func ascListDevice(client *appstoreconnect.Client, device Device) ([]appstoreconnect.Device, error) {
+// ListAllDevices returns every device registered on the Developer Portal, in any status and on any platform
+func ListAllDevices(client *appstoreconnect.Client) ([]appstoreconnect.Device, error) {
+	if client == nil {
+		return nil, fmt.Errorf("Failed to estabilish connection: App Store Connect client not provided")
+	}
+
 	var ascDevices []appstoreconnect.Device
+	var nextPageURL string
+	for {
+		response, err := client.Provisioning.ListDevices(&appstoreconnect.ListDevicesOptions{
+			PagingOptions: appstoreconnect.PagingOptions{
+				Limit: 200,
+				Next:  nextPageURL,
+			},
+		})
+		if err != nil {
+			return nil, ascError("Failed to list registered devices", err)
+		}
 
-	if client == nil {
-		return ascDevices, fmt.Errorf("Failed to estabilish connection: App Store Connect client not provided")
+		ascDevices = append(ascDevices, response.Data...)
+
+		nextPageURL = response.Links.Next
+		if nextPageURL == "" {
+			return ascDevices, nil
+		}
 	}
+}
+
+// DeviceIndex is a read-only lookup of the registered devices by UDID, safe for concurrent use
+type DeviceIndex struct {
+	devices []appstoreconnect.Device
+}
+
+// NewDeviceIndex ...
+func NewDeviceIndex(ascDevices []appstoreconnect.Device) *DeviceIndex {
+	return &DeviceIndex{devices: ascDevices}
+}
+
+// Devices returns every indexed device
+func (i *DeviceIndex) Devices() []appstoreconnect.Device {
+	return i.devices
+}
+
+// Lookup returns the registered devices with the given UDID in any status.
+// UDIDs are compared case-insensitively with the '-' separator ignored.
+func (i *DeviceIndex) Lookup(udid string) []appstoreconnect.Device {
+	var ascDevices []appstoreconnect.Device
+	for _, ascDevice := range i.devices {
+		if devportalservice.IsEqualUDID(ascDevice.Attributes.UDID, udid) {
+			ascDevices = append(ascDevices, ascDevice)
+		}
+	}
+	return ascDevices
+}
 
-	ascDevices, err := autoprovision.ListDevices(client, device.UDID, appstoreconnect.DevicePlatform(device.ASCPlatform()))
-	if err != nil {
-		rerr, ok := err.(*appstoreconnect.ErrorResponse)
-		if ok && rerr.Response != nil {
-			errorStr := fmt.Sprintf("Failed to register device %s (%s)", device.Name, device.UDID)
-			for _, error := range rerr.Errors {
-				errorStr += "\n" + error.Title + ": " + error.Detail
-			}
-			return ascDevices, fmt.Errorf("%s", errorStr)
+func ascError(message string, err error) error {
+	rerr, ok := err.(*appstoreconnect.ErrorResponse)
+	if ok && rerr.Response != nil {
+		errorStr := message
+		for _, error := range rerr.Errors {
+			errorStr += "\n" + error.Title + ": " + error.Detail
 		}
+		return fmt.Errorf("%s", errorStr)
 	}
 
-	return ascDevices, nil
+	return fmt.Errorf("%s\n%v", message, err)
 }
@@ -0,0 +1,183 @@
+package device
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-steplib/steps-deploy-to-itunesconnect-deliver/devportalservice"
+	"github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/appstoreconnect"
+)
+
+// DeviceLimitPerClass is the number of devices per device class that can be registered in a membership year.
+// Disabled devices keep occupying their slot until the membership renewal.
+const DeviceLimitPerClass = 100
+
+// DeviceClasses lists every device class with a separate device limit
+var DeviceClasses = []appstoreconnect.DeviceClass{
+	appstoreconnect.Iphone,
+	appstoreconnect.Ipad,
+	appstoreconnect.Ipod,
+	appstoreconnect.AppleWatch,
+	appstoreconnect.AppleTV,
+	appstoreconnect.Mac,
+}
+
+// Classes returns the device classes the device may belong to, based on its model identifier, UDID format or platform
+func (d Device) Classes() []appstoreconnect.DeviceClass {
+	if d.Model != "" {
+		if class, err := ClassForModel(d.Model); err == nil {
+			return []appstoreconnect.DeviceClass{class}
+		}
+	}
+	if _, format, err := ParseUDID(d.UDID); err == nil && format == MacProvisioningUDID {
+		return []appstoreconnect.DeviceClass{appstoreconnect.Mac}
+	}
+
+	switch d.ASCPlatform() {
+	case appstoreconnect.MacOS:
+		return []appstoreconnect.DeviceClass{appstoreconnect.Mac}
+	case appstoreconnect.IOS:
+		return []appstoreconnect.DeviceClass{appstoreconnect.Iphone, appstoreconnect.Ipad, appstoreconnect.Ipod, appstoreconnect.AppleWatch, appstoreconnect.AppleTV}
+	}
+
+	return DeviceClasses
+}
+
+// Quota holds the number of registered devices per device class and status
+type Quota struct {
+	Counts map[appstoreconnect.DeviceClass]map[appstoreconnect.Status]int
+}
+
+// NewQuota counts the registered devices per device class and status
+func NewQuota(ascDevices []appstoreconnect.Device) Quota {
+	counts := map[appstoreconnect.DeviceClass]map[appstoreconnect.Status]int{}
+	for _, ascDevice := range ascDevices {
+		class := ascDevice.Attributes.DeviceClass
+		if counts[class] == nil {
+			counts[class] = map[appstoreconnect.Status]int{}
+		}
+		counts[class][ascDevice.Attributes.Status]++
+	}
+
+	return Quota{Counts: counts}
+}
+
+// Used returns the number of occupied device slots of the class, disabled devices included
+func (q Quota) Used(class appstoreconnect.DeviceClass) int {
+	used := 0
+	for _, count := range q.Counts[class] {
+		used += count
+	}
+	return used
+}
+
+// Remaining returns the number of free device slots of the class
+func (q Quota) Remaining(class appstoreconnect.DeviceClass) int {
+	remaining := DeviceLimitPerClass - q.Used(class)
+	if remaining < 0 {
+		return 0
+	}
+	return remaining
+}
+
+// QuotaProjection is the projected number of occupied device slots of a device class after the registration
+type QuotaProjection struct {
+	Class appstoreconnect.DeviceClass
+	Used  int
+	// Certain is the number of new devices known to belong to the class
+	Certain int
+	// Possible is the number of new devices that may belong to the class
+	Possible int
+}
+
+// Exceeds reports whether the class surely runs out of device slots
+func (p QuotaProjection) Exceeds() bool {
+	return p.Used+p.Certain > DeviceLimitPerClass
+}
+
+// MayExceed reports whether the class runs out of device slots in the worst case
+func (p QuotaProjection) MayExceed() bool {
+	return p.Used+p.Certain+p.Possible > DeviceLimitPerClass
+}
+
+// Project returns the projected device slot usage per device class, if the given devices were registered.
+// Already registered devices (including the disabled ones) do not occupy a new slot.
+func (q Quota) Project(devices []Device, ascDevices []appstoreconnect.Device) []QuotaProjection {
+	projections := map[appstoreconnect.DeviceClass]*QuotaProjection{}
+	for _, class := range DeviceClasses {
+		projections[class] = &QuotaProjection{Class: class, Used: q.Used(class)}
+	}
+
+	for _, device := range devices {
+		device, err := device.Normalize()
+		if err != nil {
+			// Invalid devices are not registered
+			continue
+		}
+		if isRegistered(device, ascDevices) {
+			continue
+		}
+
+		classes := device.Classes()
+		for _, class := range classes {
+			if len(classes) == 1 {
+				projections[class].Certain++
+			} else {
+				projections[class].Possible++
+			}
+		}
+	}
+
+	var result []QuotaProjection
+	for _, class := range DeviceClasses {
+		result = append(result, *projections[class])
+	}
+	return result
+}
+
+func isRegistered(device Device, ascDevices []appstoreconnect.Device) bool {
+	for _, ascDevice := range ascDevices {
+		if devportalservice.IsEqualUDID(device.UDID, ascDevice.Attributes.UDID) {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckQuota logs the device slot usage per device class and returns an error if a class surely runs out of device slots.
+// If failOnExceed is false, the exceeding classes are only reported as warnings.
+func CheckQuota(quota Quota, devices []Device, ascDevices []appstoreconnect.Device, failOnExceed bool) error {
+	log.Printf("")
+	log.Infof("Device slots (limit: %d per device class)", DeviceLimitPerClass)
+
+	var exceeding []string
+	for _, projection := range quota.Project(devices, ascDevices) {
+		var statuses []string
+		for status, count := range quota.Counts[projection.Class] {
+			statuses = append(statuses, fmt.Sprintf("%s: %d", strings.ToLower(string(status)), count))
+		}
+		sort.Strings(statuses)
+
+		log.Printf("%s: %d used (%s), %d new, up to %d more", projection.Class, projection.Used, strings.Join(statuses, ", "), projection.Certain, projection.Possible)
+
+		if projection.Exceeds() {
+			exceeding = append(exceeding, fmt.Sprintf("%s (%d used, %d new)", projection.Class, projection.Used, projection.Certain))
+		} else if projection.MayExceed() {
+			log.Warnf("%s devices may run out of device slots: %d used, up to %d new", projection.Class, projection.Used, projection.Certain+projection.Possible)
+		}
+	}
+
+	if len(exceeding) == 0 {
+		return nil
+	}
+
+	message := fmt.Sprintf("Registering the devices would exceed the %d devices limit of: %s", DeviceLimitPerClass, strings.Join(exceeding, ", "))
+	if failOnExceed {
+		return fmt.Errorf("%s", message)
+	}
+
+	log.Warnf("%s", message)
+	return nil
+}
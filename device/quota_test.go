@@ -0,0 +1,139 @@
+package device
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/appstoreconnect"
+)
+
+var classModels = map[appstoreconnect.DeviceClass]string{
+	appstoreconnect.Iphone:     "iPhone13,4",
+	appstoreconnect.Ipad:       "iPad8,1",
+	appstoreconnect.Ipod:       "iPod9,1",
+	appstoreconnect.AppleWatch: "Watch6,1",
+	appstoreconnect.AppleTV:    "AppleTV11,1",
+	appstoreconnect.Mac:        "MacBookPro18,1",
+}
+
+// registeredDevices returns the given number of registered devices of the class, every other one disabled
+func registeredDevices(class appstoreconnect.DeviceClass, count int) []appstoreconnect.Device {
+	var ascDevices []appstoreconnect.Device
+	for i := 0; i < count; i++ {
+		status := appstoreconnect.Enabled
+		if i%2 == 1 {
+			status = appstoreconnect.Disabled
+		}
+		ascDevices = append(ascDevices, appstoreconnect.Device{
+			ID: fmt.Sprintf("%s-%d", class, i),
+			Attributes: appstoreconnect.DeviceAttributes{
+				UDID:        fmt.Sprintf("%040x", i),
+				DeviceClass: class,
+				Status:      status,
+			},
+		})
+	}
+	return ascDevices
+}
+
+func TestQuota_Project(t *testing.T) {
+	newDevice := Device{Name: "New", UDID: "00008030-001A2B3C4D5E6F70"}
+
+	for _, class := range DeviceClasses {
+		for _, total := range []int{99, 100, 101} {
+			t.Run(fmt.Sprintf("%s %d", class, total), func(t *testing.T) {
+				ascDevices := registeredDevices(class, total-1)
+				quota := NewQuota(ascDevices)
+				if got, want := quota.Used(class), total-1; got != want {
+					t.Fatalf("Used() = %d, want %d, disabled devices included", got, want)
+				}
+
+				device := newDevice
+				device.Model = classModels[class]
+				// An already registered device does not occupy a new slot
+				registered := Device{Name: "Registered", UDID: ascDevices[1].Attributes.UDID, Model: classModels[class]}
+
+				var projection *QuotaProjection
+				for _, p := range quota.Project([]Device{device, registered}, ascDevices) {
+					p := p
+					if p.Class == class {
+						projection = &p
+					} else if p.Certain != 0 || p.Possible != 0 {
+						t.Errorf("%s projection = %+v, want no new devices", p.Class, p)
+					}
+				}
+
+				if projection == nil || projection.Used != total-1 || projection.Certain != 1 || projection.Possible != 0 {
+					t.Fatalf("projection = %+v, want %d used and 1 new", projection, total-1)
+				}
+				if got, want := projection.Exceeds(), total > DeviceLimitPerClass; got != want {
+					t.Errorf("Exceeds() = %v, want %v", got, want)
+				}
+				if got, want := projection.MayExceed(), total > DeviceLimitPerClass; got != want {
+					t.Errorf("MayExceed() = %v, want %v", got, want)
+				}
+				if got, want := quota.Remaining(class), DeviceLimitPerClass-(total-1); got != want {
+					t.Errorf("Remaining() = %d, want %d", got, want)
+				}
+			})
+		}
+	}
+}
+
+func TestQuota_Project_Ambiguous(t *testing.T) {
+	ascDevices := registeredDevices(appstoreconnect.AppleTV, 100)
+	device := Device{Name: "Ambiguous", UDID: "00008030-001A2B3C4D5E6F70", Platform: "ios"}
+
+	for _, projection := range NewQuota(ascDevices).Project([]Device{device}, ascDevices) {
+		wantPossible := 1
+		if projection.Class == appstoreconnect.Mac {
+			wantPossible = 0
+		}
+		if projection.Certain != 0 || projection.Possible != wantPossible {
+			t.Errorf("%s projection = %+v, want %d possible", projection.Class, projection, wantPossible)
+		}
+		if projection.Exceeds() {
+			t.Errorf("%s Exceeds() = true, want false", projection.Class)
+		}
+		if got, want := projection.MayExceed(), projection.Class == appstoreconnect.AppleTV; got != want {
+			t.Errorf("%s MayExceed() = %v, want %v", projection.Class, got, want)
+		}
+	}
+}
+
+func TestCheckQuota(t *testing.T) {
+	// An iOS device without a model identifier may belong to any iOS device class
+	ambiguousDevice := Device{Name: "Ambiguous", UDID: "00008030-001A2B3C4D5E6F70", Platform: "ios"}
+
+	tests := []struct {
+		name         string
+		class        appstoreconnect.DeviceClass
+		total        int
+		ambiguous    bool
+		failOnExceed bool
+		wantErr      bool
+	}{
+		{name: "iPhone at 99", class: appstoreconnect.Iphone, total: 99, failOnExceed: true},
+		{name: "iPhone at 100", class: appstoreconnect.Iphone, total: 100, failOnExceed: true},
+		{name: "iPhone at 101 refused", class: appstoreconnect.Iphone, total: 101, failOnExceed: true, wantErr: true},
+		{name: "iPhone at 101 warned", class: appstoreconnect.Iphone, total: 101},
+		{name: "Mac at 101 refused", class: appstoreconnect.Mac, total: 101, failOnExceed: true, wantErr: true},
+		{name: "Apple TV at 101 refused", class: appstoreconnect.AppleTV, total: 101, failOnExceed: true, wantErr: true},
+		{name: "Apple TV possibly at 101 warned", class: appstoreconnect.AppleTV, total: 101, ambiguous: true, failOnExceed: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ascDevices := registeredDevices(tt.class, tt.total-1)
+
+			device := Device{Name: "New", UDID: "00008030-001A2B3C4D5E6F70", Model: classModels[tt.class]}
+			if tt.ambiguous {
+				device = ambiguousDevice
+			}
+
+			err := CheckQuota(NewQuota(ascDevices), []Device{device}, ascDevices, tt.failOnExceed)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CheckQuota() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -2,70 +2,265 @@ package device
 
 import (
 	"fmt"
+	"net/http"
+	"sync"
 
 	"github.com/bitrise-io/go-utils/log"
 	"github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/appstoreconnect"
 )
 
-func registerDevice(client *appstoreconnect.Client, device Device) error {
+// DeviceUpdateRequestDataAttributes ...
+type DeviceUpdateRequestDataAttributes struct {
+	Name   string                 `json:"name,omitempty"`
+	Status appstoreconnect.Status `json:"status,omitempty"`
+}
+
+// DeviceUpdateRequestData ...
+type DeviceUpdateRequestData struct {
+	Attributes DeviceUpdateRequestDataAttributes `json:"attributes"`
+	ID         string                            `json:"id"`
+	Type       string                            `json:"type"`
+}
+
+// DeviceUpdateRequest ...
+type DeviceUpdateRequest struct {
+	Data DeviceUpdateRequestData `json:"data"`
+}
+
+func registerDevice(client *appstoreconnect.Client, device Device) (*appstoreconnect.Device, error) {
 	if client == nil {
-		return fmt.Errorf("Failed to estabilish connection: App Store Connect client not provided")
+		return nil, fmt.Errorf("Failed to estabilish connection: App Store Connect client not provided")
+	}
+
+	udid, _, err := ParseUDID(device.UDID)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to register device %s: %v", device.Name, err)
 	}
 
 	// Register device
 	// The API seems to recognize existing devices even with different casing and '-' separator removed.
 	// The Developer Portal UI does not let adding devices with unexpected casing or separators removed.
-	// Did not fully validate the ability to add devices with changed casing (or '-' removed) via the API, so passing the UDID through unchanged.
+	// Did not fully validate the ability to add devices with changed casing (or '-' removed) via the API,
+	// so only whitespace and stray characters are stripped, casing and separators are passed through unchanged.
 	req := appstoreconnect.DeviceCreateRequest{
 		Data: appstoreconnect.DeviceCreateRequestData{
 			Attributes: appstoreconnect.DeviceCreateRequestDataAttributes{
 				Name:     device.Name,
-				UDID:     device.UDID,
+				UDID:     udid,
 				Platform: device.ASCPlatform(),
 			},
 			Type: "devices",
 		},
 	}
 
-	_, err := client.Provisioning.RegisterNewDevice(req)
+	response, err := client.Provisioning.RegisterNewDevice(req)
 	if err != nil {
-		rerr, ok := err.(*appstoreconnect.ErrorResponse)
-		if ok && rerr.Response != nil {
-			errorStr := fmt.Sprintf("Failed to register device %s (%s)", device.Name, device.UDID)
-			for _, error := range rerr.Errors {
-				errorStr += "\n" + error.Title + ": " + error.Detail
-			}
-			return fmt.Errorf("%s", errorStr)
-		}
+		return nil, ascError(fmt.Sprintf("Failed to register device %s (%s)", device.Name, device.UDID), err)
+	}
+
+	return &response.Data, nil
+}
+
+// modifyDevice updates the attributes of a registered device with a PATCH request on devices/{id}
+func modifyDevice(client *appstoreconnect.Client, id string, attributes DeviceUpdateRequestDataAttributes) (*appstoreconnect.Device, error) {
+	if client == nil {
+		return nil, fmt.Errorf("Failed to estabilish connection: App Store Connect client not provided")
+	}
+
+	body := DeviceUpdateRequest{
+		Data: DeviceUpdateRequestData{
+			Attributes: attributes,
+			ID:         id,
+			Type:       "devices",
+		},
+	}
+
+	req, err := client.NewRequest(http.MethodPatch, appstoreconnect.DevicesEndpoint+"/"+id, body)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &appstoreconnect.DeviceResponse{}
+	if _, err := client.Do(req, r); err != nil {
+		return nil, err
+	}
+
+	return &r.Data, nil
+}
+
+func enableDevice(client *appstoreconnect.Client, device Device, ascDevice appstoreconnect.Device, rename bool) error {
+	attributes := DeviceUpdateRequestDataAttributes{
+		Status: appstoreconnect.Enabled,
+	}
+	if rename {
+		attributes.Name = device.Name
+	}
+
+	if _, err := modifyDevice(client, ascDevice.ID, attributes); err != nil {
+		return ascError(fmt.Sprintf("Failed to re-enable device %s (%s)", device.Name, device.UDID), err)
 	}
 
 	return nil
 }
 
-func RegisterDevices(client *appstoreconnect.Client, devices []Device) error {
+// ModifyDeviceName renames a registered device on the Developer Portal
+func ModifyDeviceName(client *appstoreconnect.Client, device Device, ascDevice appstoreconnect.Device) error {
+	_, err := modifyDevice(client, ascDevice.ID, DeviceUpdateRequestDataAttributes{
+		Name: device.Name,
+	})
+	if err != nil {
+		return ascError(fmt.Sprintf("Failed to rename device %s (%s)", device.Name, device.UDID), err)
+	}
+
+	return nil
+}
+
+// RegisterOptions ...
+type RegisterOptions struct {
+	// RenameExisting updates the name of the already registered devices if it differs from the provided one
+	RenameExisting bool
+	// Concurrency is the maximum number of devices registered at the same time
+	Concurrency int
+	// DryRun only reports what would be changed, without changing anything on the Developer Portal
+	DryRun bool
+}
+
+func isRenameNeeded(device Device, ascDevice appstoreconnect.Device, opts RegisterOptions) bool {
+	return opts.RenameExisting && device.Name != ascDevice.Attributes.Name
+}
+
+// RegisterDevices registers the devices on the Developer Portal, with at most opts.Concurrency devices at the same time.
+// Devices are looked up in the provided index of the registered devices, if the index is nil every registered device is listed first.
+// A failing device does not stop the registration of the rest, the outcome of each device is returned in the input order.
+func RegisterDevices(client *appstoreconnect.Client, index *DeviceIndex, devices []Device, opts RegisterOptions) ([]Result, error) {
 	if client == nil {
-		return fmt.Errorf("Failed to estabilish connection: App Store Connect client not provided")
+		return nil, fmt.Errorf("Failed to estabilish connection: App Store Connect client not provided")
+	}
+
+	if index == nil {
+		ascDevices, err := ListAllDevices(client)
+		if err != nil {
+			return nil, err
+		}
+		index = NewDeviceIndex(ascDevices)
+	}
+
+	concurrency := opts.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	log.Printf("")
+	log.Infof("Registering %d device(s), %d at a time", len(devices), concurrency)
+
+	results := make([]Result, len(devices))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = registerDeviceIfNeeded(client, index, devices[i], opts)
+			}
+		}()
+	}
+	for i := range devices {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	for _, result := range results {
+		logResult(result)
+	}
+
+	return results, nil
+}
+
+func logResult(result Result) {
+	name := fmt.Sprintf("%s (%s)", result.Device.Name, result.Device.UDID)
+
+	if result.DryRun {
+		switch result.Status {
+		case StatusRegistered, StatusReEnabled:
+			log.Printf("Dry run: device %s would be %s", name, result.Status)
+			return
+		case StatusSkipped:
+			if result.PreviousName != "" {
+				log.Printf("Dry run: device %s would be renamed from %s", name, result.PreviousName)
+			} else {
+				log.Printf("Dry run: device %s is already registered", name)
+			}
+			return
+		}
+	}
+
+	switch result.Status {
+	case StatusRegistered:
+		log.Donef("Device %s successfully registered", name)
+	case StatusReEnabled:
+		log.Donef("Device %s successfully re-enabled", name)
+	case StatusSkipped:
+		if result.PreviousName != "" {
+			log.Donef("Device %s successfully renamed from %s to %s", result.Device.UDID, result.PreviousName, result.Device.Name)
+		} else {
+			log.Warnf("Device %s is already registered on App Store Connect, skipping", name)
+		}
+	case StatusInvalid:
+		log.Errorf("Invalid device: %v", result.Error)
+	case StatusFailed:
+		log.Errorf("%v", result.Error)
 	}
+}
 
-	for _, device := range devices {
-		log.Printf("")
-		log.Infof("Registering device %s (%s)", device.Name, device.UDID)
+func registerDeviceIfNeeded(client *appstoreconnect.Client, index *DeviceIndex, device Device, opts RegisterOptions) Result {
+	// Unknown or contradicting device attributes should fail before any network call
+	normalized, err := device.Normalize()
+	if err != nil {
+		return Result{Device: device, Status: StatusInvalid, Error: err}
+	}
+	device = normalized
 
-		ascDevices, err := ascListDevice(client, device)
+	ascDevices := index.Lookup(device.UDID)
+	if len(ascDevices) == 0 {
+		if opts.DryRun {
+			return Result{Device: device, Status: StatusRegistered, DryRun: true}
+		}
+
+		ascDevice, err := registerDevice(client, device)
 		if err != nil {
-			return err
+			return Result{Device: device, Status: StatusFailed, Error: err}
 		}
+		return Result{Device: device, Status: StatusRegistered, PortalID: ascDevice.ID}
+	}
+
+	ascDevice := ascDevices[0]
+	result := Result{Device: device, Status: StatusSkipped, PortalID: ascDevice.ID, DryRun: opts.DryRun}
+
+	rename := isRenameNeeded(device, ascDevice, opts)
+	if rename {
+		result.PreviousName = ascDevice.Attributes.Name
+	}
 
-		if len(ascDevices) > 0 {
-			log.Warnf("Device is already registered on App Store Connect, skipping")
-			continue
+	if ascDevice.Attributes.Status == appstoreconnect.Disabled {
+		if opts.DryRun {
+			result.Status = StatusReEnabled
+			return result
 		}
 
-		if err := registerDevice(client, device); err != nil {
-			return err
+		if err := enableDevice(client, device, ascDevice, rename); err != nil {
+			return Result{Device: device, Status: StatusFailed, PortalID: ascDevice.ID, Error: err}
 		}
-		log.Donef("Device %s (%s) successfully registered", device.Name, device.UDID)
+		result.Status = StatusReEnabled
+		return result
 	}
 
-	return nil
+	if rename && !opts.DryRun {
+		if err := ModifyDeviceName(client, device, ascDevice); err != nil {
+			return Result{Device: device, Status: StatusFailed, PortalID: ascDevice.ID, Error: err}
+		}
+	}
+
+	return result
 }
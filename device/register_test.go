@@ -0,0 +1,129 @@
+package device
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/appstoreconnect"
+)
+
+// fakeDevicePortal serves the device registrations, answering the first registration only after every other one
+type fakeDevicePortal struct {
+	mu            sync.Mutex
+	requests      []string
+	registrations int
+	// others is closed once every registration but the first one is answered
+	others         chan struct{}
+	expectedOthers int
+}
+
+func (p *fakeDevicePortal) Do(req *http.Request) (*http.Response, error) {
+	p.mu.Lock()
+	p.requests = append(p.requests, req.Method+" "+req.URL.Path)
+	p.mu.Unlock()
+
+	var body interface{}
+	switch req.Method {
+	case http.MethodPost:
+		var request appstoreconnect.DeviceCreateRequest
+		if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+			return nil, err
+		}
+
+		if request.Data.Attributes.Name == "device-0" {
+			select {
+			case <-p.others:
+			case <-time.After(5 * time.Second):
+				return nil, fmt.Errorf("the other registrations did not run concurrently")
+			}
+		} else {
+			p.mu.Lock()
+			p.registrations++
+			if p.registrations == p.expectedOthers {
+				defer close(p.others)
+			}
+			p.mu.Unlock()
+		}
+
+		body = appstoreconnect.DeviceResponse{Data: appstoreconnect.Device{
+			Type:       "devices",
+			ID:         "id-" + request.Data.Attributes.Name,
+			Attributes: appstoreconnect.DeviceAttributes{Name: request.Data.Attributes.Name, UDID: request.Data.Attributes.UDID},
+		}}
+	case http.MethodPatch:
+		body = appstoreconnect.DeviceResponse{Data: appstoreconnect.Device{Type: "devices", ID: strings.TrimPrefix(req.URL.Path, "/v1/devices/")}}
+	default:
+		return &http.Response{StatusCode: http.StatusNotFound, Body: ioutil.NopCloser(bytes.NewReader([]byte("{}"))), Request: req}, nil
+	}
+
+	content, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(bytes.NewReader(content)), Request: req}, nil
+}
+
+func TestRegisterDevices(t *testing.T) {
+	udid := func(i int) string {
+		return fmt.Sprintf("%040x", i+1)
+	}
+
+	var devices []Device
+	for i := 0; i < 6; i++ {
+		devices = append(devices, Device{Name: fmt.Sprintf("device-%d", i), UDID: udid(i), Platform: "ios"})
+	}
+	devices = append(devices, Device{Name: "invalid", UDID: "0123", Platform: "ios"})
+
+	index := NewDeviceIndex([]appstoreconnect.Device{
+		{ID: "registered", Attributes: appstoreconnect.DeviceAttributes{UDID: strings.ToUpper(udid(4)), Status: appstoreconnect.Enabled, Name: "device-4"}},
+		{ID: "disabled", Attributes: appstoreconnect.DeviceAttributes{UDID: udid(5), Status: appstoreconnect.Disabled, Name: "device-5"}},
+	})
+
+	portal := &fakeDevicePortal{others: make(chan struct{}), expectedOthers: 3}
+	client := appstoreconnect.NewClient(portal, "", "", nil)
+
+	results, err := RegisterDevices(client, index, devices, RegisterOptions{Concurrency: 3})
+	if err != nil {
+		t.Fatalf("RegisterDevices() error = %v", err)
+	}
+
+	want := []struct {
+		status   Status
+		portalID string
+	}{
+		{status: StatusRegistered, portalID: "id-device-0"},
+		{status: StatusRegistered, portalID: "id-device-1"},
+		{status: StatusRegistered, portalID: "id-device-2"},
+		{status: StatusRegistered, portalID: "id-device-3"},
+		{status: StatusSkipped, portalID: "registered"},
+		{status: StatusReEnabled, portalID: "disabled"},
+		{status: StatusInvalid},
+	}
+	if len(results) != len(want) {
+		t.Fatalf("RegisterDevices() = %d results, want %d", len(results), len(want))
+	}
+	for i, result := range results {
+		if result.Device.Name != devices[i].Name {
+			t.Errorf("results[%d].Device = %s, want %s in the input order", i, result.Device.Name, devices[i].Name)
+		}
+		if result.Status != want[i].status || result.PortalID != want[i].portalID {
+			t.Errorf("results[%d] = %s (%s), error: %v, want %s (%s)", i, result.Status, result.PortalID, result.Error, want[i].status, want[i].portalID)
+		}
+	}
+
+	for _, request := range portal.requests {
+		if strings.HasPrefix(request, http.MethodGet) {
+			t.Errorf("request %s, want the devices looked up in the index", request)
+		}
+	}
+	if got, want := len(portal.requests), 5; got != want {
+		t.Errorf("%d requests (%v), want %d", got, portal.requests, want)
+	}
+}
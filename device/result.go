@@ -0,0 +1,121 @@
+package device
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+// Status is the outcome of a device registration
+type Status string
+
+// Statuses ...
+const (
+	StatusRegistered Status = "registered"
+	StatusReEnabled  Status = "re-enabled"
+	StatusSkipped    Status = "skipped"
+	StatusInvalid    Status = "invalid"
+	StatusFailed     Status = "failed"
+	// StatusDisabled is the outcome of a decommissioned device
+	StatusDisabled Status = "disabled"
+)
+
+// Statuses lists every registration status in the order they are reported
+var Statuses = []Status{StatusRegistered, StatusReEnabled, StatusSkipped, StatusInvalid, StatusFailed}
+
+// Result is the outcome of a single device registration
+type Result struct {
+	Device Device
+	Status Status
+	// PortalID is the ID of the device on the Developer Portal
+	PortalID string
+	// PreviousName is the name of the device on the Developer Portal before it was renamed, empty if it was not renamed
+	PreviousName string
+	Error        error
+	// DryRun marks the results of a dry run, the status is the planned outcome
+	DryRun bool
+}
+
+// Succeeded reports whether the device is registered and enabled on the Developer Portal
+func (r Result) Succeeded() bool {
+	return r.Status == StatusRegistered || r.Status == StatusReEnabled || r.Status == StatusSkipped
+}
+
+// MarshalJSON ...
+func (r Result) MarshalJSON() ([]byte, error) {
+	var errorMessage string
+	if r.Error != nil {
+		errorMessage = r.Error.Error()
+	}
+
+	return json.Marshal(struct {
+		Name         string `json:"name"`
+		UDID         string `json:"udid"`
+		Platform     string `json:"platform"`
+		Model        string `json:"model,omitempty"`
+		Status       Status `json:"status"`
+		PortalID     string `json:"portal_id,omitempty"`
+		PreviousName string `json:"previous_name,omitempty"`
+		Error        string `json:"error,omitempty"`
+		DryRun       bool   `json:"dry_run,omitempty"`
+	}{
+		Name:         r.Device.Name,
+		UDID:         r.Device.UDID,
+		Platform:     r.Device.Platform,
+		Model:        r.Device.Model,
+		Status:       r.Status,
+		PortalID:     r.PortalID,
+		PreviousName: r.PreviousName,
+		Error:        errorMessage,
+		DryRun:       r.DryRun,
+	})
+}
+
+// CountByStatus returns the number of results per status
+func CountByStatus(results []Result) map[Status]int {
+	counts := map[Status]int{}
+	for _, result := range results {
+		counts[result.Status]++
+	}
+	return counts
+}
+
+// SucceededDevices returns the devices which are registered and enabled on the Developer Portal
+func SucceededDevices(results []Result) []Device {
+	var devices []Device
+	for _, result := range results {
+		if result.Succeeded() {
+			devices = append(devices, result.Device)
+		}
+	}
+	return devices
+}
+
+// PrintSummary logs the registration results grouped by status
+func PrintSummary(results []Result) {
+	log.Printf("")
+	log.Infof("Device registration summary")
+
+	counts := CountByStatus(results)
+	for _, status := range Statuses {
+		log.Printf("%s: %d", status, counts[status])
+		for _, result := range results {
+			if result.Status != status {
+				continue
+			}
+
+			line := fmt.Sprintf("- %s (%s)", result.Device.Name, result.Device.UDID)
+			if result.PreviousName != "" {
+				line += fmt.Sprintf(", renamed from %s", result.PreviousName)
+			}
+
+			switch status {
+			case StatusInvalid, StatusFailed:
+				log.Errorf("%s: %v", line, result.Error)
+			default:
+				log.Printf("%s", line)
+			}
+		}
+	}
+}
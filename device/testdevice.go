@@ -0,0 +1,23 @@
+package device
+
+import (
+	"strings"
+
+	"github.com/bitrise-steplib/steps-deploy-to-itunesconnect-deliver/devportalservice"
+)
+
+// NewDeviceFromTestDevice converts a test device registered on bitrise.io to a Device.
+// Apple TV and Apple Watch devices are registered with the iOS platform on the Developer Portal.
+func NewDeviceFromTestDevice(testDevice devportalservice.TestDevice) Device {
+	platform := "ios"
+	switch strings.ToLower(testDevice.DeviceType) {
+	case "macos", "mac", "osx":
+		platform = "macos"
+	}
+
+	return Device{
+		Name:     testDevice.Title,
+		UDID:     testDevice.DeviceID,
+		Platform: platform,
+	}
+}
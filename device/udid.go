@@ -0,0 +1,88 @@
+package device
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// UDIDFormat ...
+type UDIDFormat string
+
+// UDIDFormats ...
+const (
+	// LegacyUDID is the 40 hexadecimal digit UDID of devices before the iPhone XS
+	LegacyUDID UDIDFormat = "legacy"
+	// ModernUDID is the XXXXXXXX-XXXXXXXXXXXXXXXX UDID of newer iOS devices and Apple silicon Macs
+	ModernUDID UDIDFormat = "modern"
+	// MacProvisioningUDID is the XXXXXXXX-XXXX-XXXX-XXXX-XXXXXXXXXXXX provisioning UDID (hardware UUID) of Intel Macs
+	MacProvisioningUDID UDIDFormat = "mac"
+)
+
+var udidLayouts = []struct {
+	format UDIDFormat
+	groups []int
+}{
+	{format: LegacyUDID, groups: []int{40}},
+	{format: ModernUDID, groups: []int{8, 16}},
+	{format: MacProvisioningUDID, groups: []int{8, 4, 4, 4, 12}},
+}
+
+// ParseUDID strips whitespace and stray characters (quotes, invisible characters, etc.) from the given UDID
+// and checks it against the known UDID formats.
+// The casing is left unchanged, as the Developer Portal UI does not accept UDIDs with unexpected casing.
+func ParseUDID(udid string) (string, UDIDFormat, error) {
+	var cleaned strings.Builder
+	for _, r := range udid {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-') {
+			cleaned.WriteRune(r)
+		}
+	}
+
+	value := cleaned.String()
+	if value == "" {
+		return "", "", fmt.Errorf("UDID is empty")
+	}
+
+	for i, r := range value {
+		if r != '-' && !isHexDigit(r) {
+			return "", "", fmt.Errorf("UDID (%s) contains a non-hexadecimal character '%c' at position %d", value, r, i+1)
+		}
+	}
+
+	groups := strings.Split(value, "-")
+	var groupLengths []int
+	for _, group := range groups {
+		groupLengths = append(groupLengths, len(group))
+	}
+
+	for _, layout := range udidLayouts {
+		if equalInts(groupLengths, layout.groups) {
+			return value, layout.format, nil
+		}
+	}
+
+	digits := len(strings.Replace(value, "-", "", -1))
+	switch digits {
+	case 40, 24, 32:
+		return "", "", fmt.Errorf("UDID (%s) has %d hexadecimal digits, but its '-' separators are misplaced (group lengths: %v)", value, digits, groupLengths)
+	}
+
+	return "", "", fmt.Errorf("UDID (%s) has %d hexadecimal digits, expected 40 (legacy), 8-16 (modern) or 8-4-4-4-12 (Mac provisioning UDID)", value, digits)
+}
+
+func isHexDigit(r rune) bool {
+	return ('0' <= r && r <= '9') || ('a' <= r && r <= 'f') || ('A' <= r && r <= 'F')
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
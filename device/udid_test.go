@@ -0,0 +1,124 @@
+package device
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseUDID(t *testing.T) {
+	tests := []struct {
+		name       string
+		udid       string
+		want       string
+		wantFormat UDIDFormat
+		wantErr    string
+	}{
+		{
+			name:       "legacy",
+			udid:       "0123456789abcdef0123456789abcdef01234567",
+			want:       "0123456789abcdef0123456789abcdef01234567",
+			wantFormat: LegacyUDID,
+		},
+		{
+			name:       "legacy keeps the casing",
+			udid:       "0123456789ABCDEF0123456789abcdef01234567",
+			want:       "0123456789ABCDEF0123456789abcdef01234567",
+			wantFormat: LegacyUDID,
+		},
+		{
+			name:       "modern",
+			udid:       "00008030-001A2B3C4D5E6F70",
+			want:       "00008030-001A2B3C4D5E6F70",
+			wantFormat: ModernUDID,
+		},
+		{
+			name:       "Mac provisioning UDID",
+			udid:       "A1B2C3D4-E5F6-A7B8-C9D0-E1F2A3B4C5D6",
+			want:       "A1B2C3D4-E5F6-A7B8-C9D0-E1F2A3B4C5D6",
+			wantFormat: MacProvisioningUDID,
+		},
+		{
+			name:       "surrounding whitespace",
+			udid:       "  00008030-001A2B3C4D5E6F70\n",
+			want:       "00008030-001A2B3C4D5E6F70",
+			wantFormat: ModernUDID,
+		},
+		{
+			name:       "quotes and invisible characters",
+			udid:       "\"\u200b00008030-001A2B3C4D5E6F70\u00a0\"",
+			want:       "00008030-001A2B3C4D5E6F70",
+			wantFormat: ModernUDID,
+		},
+		{
+			name:       "inner whitespace",
+			udid:       "00008030 - 001A2B3C4D5E6F70",
+			want:       "00008030-001A2B3C4D5E6F70",
+			wantFormat: ModernUDID,
+		},
+		{
+			name:    "empty",
+			udid:    "",
+			wantErr: "UDID is empty",
+		},
+		{
+			name:    "only stray characters",
+			udid:    "\" \"",
+			wantErr: "UDID is empty",
+		},
+		{
+			name:    "non-hexadecimal character",
+			udid:    "00008030-001A2B3C4D5E6F7G",
+			wantErr: "non-hexadecimal character 'G' at position 25",
+		},
+		{
+			name:    "misplaced modern separator",
+			udid:    "0000803-0001A2B3C4D5E6F70",
+			wantErr: "separators are misplaced",
+		},
+		{
+			name:    "modern without separator",
+			udid:    "00008030001A2B3C4D5E6F70",
+			wantErr: "separators are misplaced",
+		},
+		{
+			name:    "misplaced Mac separators",
+			udid:    "A1B2C3D4E5F6-A7B8-C9D0-E1F2-A3B4C5D6",
+			wantErr: "separators are misplaced",
+		},
+		{
+			name:    "legacy with separator",
+			udid:    "0123456789abcdef0123-456789abcdef01234567",
+			wantErr: "separators are misplaced",
+		},
+		{
+			name:    "too short",
+			udid:    "0123456789abcdef",
+			wantErr: "has 16 hexadecimal digits",
+		},
+		{
+			name:    "too long",
+			udid:    "0123456789abcdef0123456789abcdef012345678",
+			wantErr: "has 41 hexadecimal digits",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, format, err := ParseUDID(tt.udid)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ParseUDID() error = %v, want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseUDID() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("ParseUDID() = %s, want %s", got, tt.want)
+			}
+			if format != tt.wantFormat {
+				t.Errorf("ParseUDID() format = %s, want %s", format, tt.wantFormat)
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/bitrise-io/go-steputils/tools"
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-io/go-xcode/exportoptions"
+	"github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/appstoreconnect"
+	"howett.net/plist"
+)
+
+const stripSwiftSymbolsKey = "stripSwiftSymbols"
+
+// exportMethod returns the Xcode export method of the profile type
+func exportMethod(profileType appstoreconnect.ProfileType) exportoptions.Method {
+	switch profileType {
+	case appstoreconnect.IOSAppStore, appstoreconnect.TvOSAppStore, appstoreconnect.MacAppStore:
+		return exportoptions.MethodAppStore
+	case appstoreconnect.IOSAppInHouse, appstoreconnect.TvOSAppInHouse:
+		return exportoptions.MethodEnterprise
+	case appstoreconnect.IOSAppAdHoc, appstoreconnect.TvOSAppAdHoc:
+		return exportoptions.MethodAdHoc
+	case appstoreconnect.IOSAppDevelopment, appstoreconnect.TvOSAppDevelopment, appstoreconnect.MacAppDevelopment:
+		return exportoptions.MethodDevelopment
+	case appstoreconnect.MacAppDirect:
+		return exportoptions.MethodDeveloperID
+	}
+	return ""
+}
+
+// ExportOptions describes the export of the xcarchive with the provisioning profiles
+type ExportOptions struct {
+	DistributionBundleIdentifier string
+	Method                       exportoptions.Method
+	TeamID                       string
+	SigningCertificate           string
+	// ProvisioningProfiles maps the bundle IDs to the provisioning profile names
+	ProvisioningProfiles map[string]string
+	// Manifest describes the over-the-air installation, used by non App Store exports
+	Manifest exportoptions.Manifest
+}
+
+// Hash returns the export options as a plist dictionary, including the options set by the step inputs
+func (o ExportOptions) Hash(config Config) (map[string]interface{}, error) {
+	iCloudContainerEnvironment := exportoptions.ICloudContainerEnvironment(config.ExportICloudContainerEnvironment)
+	switch iCloudContainerEnvironment {
+	case "", exportoptions.ICloudContainerEnvironmentDevelopment, exportoptions.ICloudContainerEnvironmentProduction:
+	default:
+		return nil, fmt.Errorf("Invalid export_icloud_container_environment: %s, available options: %s, %s", iCloudContainerEnvironment, exportoptions.ICloudContainerEnvironmentDevelopment, exportoptions.ICloudContainerEnvironmentProduction)
+	}
+
+	var options exportoptions.ExportOptions
+	if o.Method == exportoptions.MethodAppStore {
+		appStoreOptions := exportoptions.NewAppStoreOptions()
+		appStoreOptions.TeamID = o.TeamID
+		appStoreOptions.BundleIDProvisioningProfileMapping = o.ProvisioningProfiles
+		appStoreOptions.SigningCertificate = o.SigningCertificate
+		appStoreOptions.SigningStyle = config.ExportSigningStyle
+		appStoreOptions.ICloudContainerEnvironment = iCloudContainerEnvironment
+		appStoreOptions.DistributionBundleIdentifier = o.DistributionBundleIdentifier
+		appStoreOptions.UploadSymbols = config.ExportUploadSymbols
+		options = appStoreOptions
+	} else {
+		nonAppStoreOptions := exportoptions.NewNonAppStoreOptions(o.Method)
+		nonAppStoreOptions.TeamID = o.TeamID
+		nonAppStoreOptions.BundleIDProvisioningProfileMapping = o.ProvisioningProfiles
+		nonAppStoreOptions.SigningCertificate = o.SigningCertificate
+		nonAppStoreOptions.SigningStyle = config.ExportSigningStyle
+		nonAppStoreOptions.ICloudContainerEnvironment = iCloudContainerEnvironment
+		nonAppStoreOptions.DistributionBundleIdentifier = o.DistributionBundleIdentifier
+		nonAppStoreOptions.CompileBitcode = config.ExportCompileBitcode
+		nonAppStoreOptions.Thinning = config.ExportThinning
+		nonAppStoreOptions.Manifest = o.Manifest
+		options = nonAppStoreOptions
+	}
+
+	hash := options.Hash()
+	// stripSwiftSymbols is not part of the go-xcode models, Xcode strips the Swift symbols by default
+	if !config.ExportStripSwiftSymbols {
+		hash[stripSwiftSymbolsKey] = false
+	}
+	return hash, nil
+}
+
+// exportOptionsPath returns the path to write the export options plist to, empty if neither export_options_path nor deploy_dir is set
+func exportOptionsPath(config Config) string {
+	if config.ExportOptionsPath != "" {
+		return config.ExportOptionsPath
+	}
+	if config.DeployDir == "" {
+		return ""
+	}
+	return filepath.Join(config.DeployDir, "export_options.plist")
+}
+
+// serializeExportOptions returns the export options as an XML plist and as JSON.
+// Both encoders sort the map keys, so the same options always serialize to the same bytes.
+func serializeExportOptions(hash map[string]interface{}) ([]byte, []byte, error) {
+	content, err := plist.MarshalIndent(hash, plist.XMLFormat, "\t")
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to serialize export options\n%v", err)
+	}
+
+	jsonContent, err := json.Marshal(hash)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to serialize export options to JSON\n%v", err)
+	}
+	return content, jsonContent, nil
+}
+
+// exportExportOptions exports the export options plist and its JSON representation,
+// then writes the plist to a file and exports its path. It returns the path, empty if the file is not written.
+func exportExportOptions(options ExportOptions, config Config) (string, error) {
+	hash, err := options.Hash(config)
+	if err != nil {
+		return "", err
+	}
+
+	content, jsonContent, err := serializeExportOptions(hash)
+	if err != nil {
+		return "", err
+	}
+
+	for _, output := range []struct {
+		env   string
+		value string
+	}{
+		{env: "BITRISE_XCARCHIVE_EXPORT_OPTIONS", value: string(content)},
+		{env: "BITRISE_XCARCHIVE_EXPORT_OPTIONS_JSON", value: string(jsonContent)},
+	} {
+		if err := tools.ExportEnvironmentWithEnvman(output.env, output.value); err != nil {
+			return "", fmt.Errorf("Failed to export %s\n%v", output.env, err)
+		}
+	}
+
+	pth := exportOptionsPath(config)
+	if pth == "" {
+		log.Warnf("Neither export_options_path nor deploy_dir is set, the export options plist is not written to a file")
+		return "", nil
+	}
+	if err := os.MkdirAll(filepath.Dir(pth), 0755); err != nil {
+		return "", fmt.Errorf("Failed to create directory for export options: %s\n%v", pth, err)
+	}
+	if err := ioutil.WriteFile(pth, content, 0644); err != nil {
+		return "", fmt.Errorf("Failed to write export options: %s\n%v", pth, err)
+	}
+
+	if err := tools.ExportEnvironmentWithEnvman("BITRISE_XCARCHIVE_EXPORT_OPTIONS_PATH", pth); err != nil {
+		return "", fmt.Errorf("Failed to export BITRISE_XCARCHIVE_EXPORT_OPTIONS_PATH\n%v", err)
+	}
+
+	return pth, nil
+}
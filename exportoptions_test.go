@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/bitrise-io/go-xcode/exportoptions"
+	"howett.net/plist"
+)
+
+func TestSerializeExportOptions(t *testing.T) {
+	bundleIDs := []string{"io.bitrise.sample", "io.bitrise.sample.share", "io.bitrise.sample.widget", "io.bitrise.sample.watchkitapp"}
+	profileNames := map[string]string{
+		"io.bitrise.sample":             "Sample & Co Ad Hoc",
+		"io.bitrise.sample.share":       "Share <Extension>",
+		"io.bitrise.sample.widget":      `Widget "Today"`,
+		"io.bitrise.sample.watchkitapp": "Watch 'App' & <Complication>",
+	}
+	config := Config{ExportThinning: "none", ExportStripSwiftSymbols: true}
+
+	for _, method := range []exportoptions.Method{exportoptions.MethodAppStore, exportoptions.MethodAdHoc} {
+		t.Run(string(method), func(t *testing.T) {
+			var contents, jsonContents [][]byte
+			// The profile maps are filled in different orders, the output should not depend on it
+			for _, order := range [][]string{bundleIDs, {bundleIDs[3], bundleIDs[1], bundleIDs[0], bundleIDs[2]}} {
+				provisioningProfiles := map[string]string{}
+				for _, bundleID := range order {
+					provisioningProfiles[bundleID] = profileNames[bundleID]
+				}
+
+				options := ExportOptions{
+					DistributionBundleIdentifier: "io.bitrise.sample",
+					Method:                       method,
+					TeamID:                       "ABCDE12345",
+					SigningCertificate:           "Apple Distribution: Bitrise & Co (ABCDE12345)",
+					ProvisioningProfiles:         provisioningProfiles,
+					Manifest: exportoptions.Manifest{
+						AppURL:           "https://example.com/app.ipa?build=1&token=<secret>",
+						DisplayImageURL:  "https://example.com/display.png",
+						FullSizeImageURL: "https://example.com/full_size.png",
+					},
+				}
+				hash, err := options.Hash(config)
+				if err != nil {
+					t.Fatalf("Hash() error = %v", err)
+				}
+
+				content, jsonContent, err := serializeExportOptions(hash)
+				if err != nil {
+					t.Fatalf("serializeExportOptions() error = %v", err)
+				}
+				contents = append(contents, content)
+				jsonContents = append(jsonContents, jsonContent)
+			}
+
+			if !bytes.Equal(contents[0], contents[1]) {
+				t.Errorf("plist differs between runs:\n%s\n%s", contents[0], contents[1])
+			}
+			if !bytes.Equal(jsonContents[0], jsonContents[1]) {
+				t.Errorf("JSON differs between runs:\n%s\n%s", jsonContents[0], jsonContents[1])
+			}
+
+			var parsed map[string]interface{}
+			if _, err := plist.Unmarshal(contents[0], &parsed); err != nil {
+				t.Fatalf("plist.Unmarshal() error = %v\n%s", err, contents[0])
+			}
+			if got := parsed["method"]; got != string(method) {
+				t.Errorf("method = %v, want %s", got, method)
+			}
+			if got, want := parsed["signingCertificate"], "Apple Distribution: Bitrise & Co (ABCDE12345)"; got != want {
+				t.Errorf("signingCertificate = %v, want %s", got, want)
+			}
+
+			if method != exportoptions.MethodAppStore {
+				manifest, ok := parsed["manifest"].(map[string]interface{})
+				if !ok {
+					t.Fatalf("manifest = %v, want a dictionary", parsed["manifest"])
+				}
+				if got, want := manifest["appURL"], "https://example.com/app.ipa?build=1&token=<secret>"; got != want {
+					t.Errorf("manifest appURL = %v, want %s", got, want)
+				}
+			}
+
+			parsedProfiles := map[string]string{}
+			for bundleID, name := range parsed["provisioningProfiles"].(map[string]interface{}) {
+				parsedProfiles[bundleID] = name.(string)
+			}
+			if !reflect.DeepEqual(parsedProfiles, profileNames) {
+				t.Errorf("provisioningProfiles = %v, want %v", parsedProfiles, profileNames)
+			}
+
+			var parsedJSON map[string]interface{}
+			if err := json.Unmarshal(jsonContents[0], &parsedJSON); err != nil {
+				t.Fatalf("json.Unmarshal() error = %v", err)
+			}
+			if !reflect.DeepEqual(parsedJSON["provisioningProfiles"], parsed["provisioningProfiles"]) {
+				t.Errorf("JSON provisioningProfiles = %v, want %v", parsedJSON["provisioningProfiles"], parsed["provisioningProfiles"])
+			}
+		})
+	}
+}
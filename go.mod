@@ -5,6 +5,9 @@ go 1.16
 require (
 	github.com/bitrise-io/go-steputils v0.0.0-20201016102104-03ae3a6ded35
 	github.com/bitrise-io/go-utils v0.0.0-20210316133228-449620935158
+	github.com/bitrise-io/go-xcode v0.0.0-20210112081035-13f817b37b1c
 	github.com/bitrise-steplib/steps-deploy-to-itunesconnect-deliver v0.0.0-20210225084122-4a4d9384c633
 	github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect v0.0.0-20210305115644-d322784b7182
+	github.com/fullsailor/pkcs7 v0.0.0-20190404230743-d7302db945fa
+	howett.net/plist v0.0.0-20201203080718-1454fab16a06
 )
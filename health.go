@@ -0,0 +1,76 @@
+package main
+
+import (
+	"crypto/x509"
+	"fmt"
+	"time"
+
+	"github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/appstoreconnect"
+)
+
+// ListProfileCertificates returns the certificates of the profile
+func ListProfileCertificates(client *appstoreconnect.Client, profile *appstoreconnect.Profile) ([]appstoreconnect.Certificate, error) {
+	var certificates []appstoreconnect.Certificate
+	var nextPageURL string
+
+	for {
+		response, err := client.Provisioning.Certificates(
+			profile.Relationships.Certificates.Links.Related,
+			&appstoreconnect.PagingOptions{
+				Limit: 20,
+				Next:  nextPageURL,
+			},
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		certificates = append(certificates, response.Data...)
+
+		nextPageURL = response.Links.Next
+		if nextPageURL == "" {
+			return certificates, nil
+		}
+	}
+}
+
+// isCertificateExpired reports whether the certificate is expired, along with its expiry
+func isCertificateExpired(certificate appstoreconnect.Certificate) (bool, time.Time) {
+	cert, err := x509.ParseCertificate(certificate.Attributes.CertificateContent)
+	if err != nil {
+		// The certificate content is not always included, the expiration date attribute is used instead
+		expiry, err := time.Parse(time.RFC3339, certificate.Attributes.ExpirationDate)
+		if err != nil {
+			return false, time.Time{}
+		}
+		return time.Now().After(expiry), expiry
+	}
+	return time.Now().After(cert.NotAfter), cert.NotAfter
+}
+
+// ProfileHealthProblems returns the reasons the profile has to be recreated, even if it already includes every device:
+// it is not active, it expires in less than minDaysValid days, or one of its certificates is expired.
+func ProfileHealthProblems(client *appstoreconnect.Client, profile *appstoreconnect.Profile, minDaysValid int) ([]string, error) {
+	var problems []string
+
+	if profile.Attributes.ProfileState != appstoreconnect.Active {
+		problems = append(problems, fmt.Sprintf("profile state is %s", profile.Attributes.ProfileState))
+	}
+
+	expiry := time.Time(profile.Attributes.ExpirationDate)
+	if time.Now().Add(time.Duration(minDaysValid) * 24 * time.Hour).After(expiry) {
+		problems = append(problems, fmt.Sprintf("profile expires at %s, less than %d day(s) from now", expiry, minDaysValid))
+	}
+
+	certificates, err := ListProfileCertificates(client, profile)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list certificates of provisioning profile %s (%s)\n%v", profile.Attributes.Name, profile.Attributes.UUID, err)
+	}
+	for _, certificate := range certificates {
+		if expired, expiry := isCertificateExpired(certificate); expired {
+			problems = append(problems, fmt.Sprintf("certificate %s expired at %s", certificate.Attributes.DisplayName, expiry))
+		}
+	}
+
+	return problems, nil
+}
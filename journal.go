@@ -0,0 +1,266 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/appstoreconnect"
+	"github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/autoprovision"
+)
+
+const profileCreateAttempts = 3
+
+// profileCreateRetryDelay is multiplied by the attempt number before each retry
+var profileCreateRetryDelay = 5 * time.Second
+
+// JournalState is the progress of a profile regeneration
+type JournalState string
+
+// JournalStates ...
+const (
+	// JournalSnapshotted means the original profile is saved, but it may not be deleted yet
+	JournalSnapshotted JournalState = "snapshotted"
+	// JournalDeleted means the original profile is deleted, but the new one is not created yet
+	JournalDeleted JournalState = "deleted"
+)
+
+// ProfileSnapshot holds everything needed to restore a deleted profile
+type ProfileSnapshot struct {
+	ID               string                      `json:"id"`
+	Name             string                      `json:"name"`
+	UUID             string                      `json:"uuid"`
+	ProfileType      appstoreconnect.ProfileType `json:"profile_type"`
+	BundleIDID       string                      `json:"bundle_id_id"`
+	BundleIdentifier string                      `json:"bundle_identifier"`
+	CertificateIDs   []string                    `json:"certificate_ids"`
+	DeviceIDs        []string                    `json:"device_ids"`
+	ProfileContent   []byte                      `json:"profile_content"`
+}
+
+// JournalEntry is an unfinished profile regeneration
+type JournalEntry struct {
+	State    JournalState    `json:"state"`
+	Snapshot ProfileSnapshot `json:"snapshot"`
+	// TargetDeviceIDs are the devices the regenerated profile should include
+	TargetDeviceIDs []string `json:"target_device_ids"`
+	// TargetCertificateIDs are the still valid certificates of the original profile, both the regenerated and the restored profile include them
+	TargetCertificateIDs []string `json:"target_certificate_ids"`
+}
+
+// Journal records the profile regenerations in progress, so that an interrupted regeneration can be resumed by a later run
+type Journal struct {
+	path    string
+	Entries []JournalEntry `json:"entries"`
+}
+
+// OpenJournal reads the journal at the given path, a missing file is an empty journal
+func OpenJournal(pth string) (*Journal, error) {
+	journal := &Journal{path: pth}
+
+	content, err := ioutil.ReadFile(pth)
+	if os.IsNotExist(err) {
+		return journal, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("Failed to read profile regeneration journal: %s\n%v", pth, err)
+	}
+
+	if err := json.Unmarshal(content, journal); err != nil {
+		return nil, fmt.Errorf("Failed to parse profile regeneration journal: %s\n%v", pth, err)
+	}
+	return journal, nil
+}
+
+func (j *Journal) save() error {
+	if len(j.Entries) == 0 {
+		if err := os.Remove(j.path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("Failed to remove profile regeneration journal: %s\n%v", j.path, err)
+		}
+		return nil
+	}
+
+	content, err := json.MarshalIndent(j, "", "  ")
+	if err != nil {
+		return fmt.Errorf("Failed to serialize profile regeneration journal\n%v", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(j.path), 0700); err != nil {
+		return fmt.Errorf("Failed to create directory for profile regeneration journal: %s\n%v", j.path, err)
+	}
+
+	// Write to a temporary file first, an interrupted write should not corrupt the journal
+	tmpPath := j.path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, content, 0600); err != nil {
+		return fmt.Errorf("Failed to write profile regeneration journal: %s\n%v", j.path, err)
+	}
+	if err := os.Rename(tmpPath, j.path); err != nil {
+		return fmt.Errorf("Failed to write profile regeneration journal: %s\n%v", j.path, err)
+	}
+	return nil
+}
+
+func (j *Journal) begin(entry JournalEntry) (int, error) {
+	j.Entries = append(j.Entries, entry)
+	return len(j.Entries) - 1, j.save()
+}
+
+func (j *Journal) update(index int, state JournalState) error {
+	j.Entries[index].State = state
+	return j.save()
+}
+
+func (j *Journal) finish(index int) error {
+	j.Entries = append(j.Entries[:index], j.Entries[index+1:]...)
+	return j.save()
+}
+
+// createProfileWithRetry creates the profile, retrying on failure
+func createProfileWithRetry(client *appstoreconnect.Client, snapshot ProfileSnapshot, certificateIDs, deviceIDs []string) (*appstoreconnect.Profile, error) {
+	bundleID := appstoreconnect.BundleID{
+		ID: snapshot.BundleIDID,
+		Attributes: appstoreconnect.BundleIDAttributes{
+			Identifier: snapshot.BundleIdentifier,
+		},
+	}
+
+	var err error
+	for attempt := 1; attempt <= profileCreateAttempts; attempt++ {
+		var profile *appstoreconnect.Profile
+		profile, err = autoprovision.CreateProfile(client, snapshot.Name, snapshot.ProfileType, bundleID, certificateIDs, deviceIDs)
+		if err == nil {
+			return profile, nil
+		}
+
+		if attempt < profileCreateAttempts {
+			log.Warnf("Attempt %d to create provisioning profile %s failed, retrying:\n%v", attempt, snapshot.Name, err)
+			time.Sleep(time.Duration(attempt) * profileCreateRetryDelay)
+		}
+	}
+
+	return nil, err
+}
+
+// complete creates the regenerated profile of a journal entry whose original profile is deleted.
+// If the creation fails, the original profile is restored from the snapshot with its still valid certificates.
+func (j *Journal) complete(client *appstoreconnect.Client, index int) (*appstoreconnect.Profile, error) {
+	entry := j.Entries[index]
+
+	log.Printf("Recreating provisioning profile on Apple Developer Portal")
+	profile, err := createProfileWithRetry(client, entry.Snapshot, entry.TargetCertificateIDs, entry.TargetDeviceIDs)
+	if err == nil {
+		return profile, j.finish(index)
+	}
+	createErr := err
+
+	log.Warnf("Failed to recreate provisioning profile %s, restoring the original profile from the snapshot", entry.Snapshot.Name)
+	// The Developer Portal rejects expired and revoked certificates, the restored profile includes only the valid ones
+	if _, err := createProfileWithRetry(client, entry.Snapshot, entry.TargetCertificateIDs, entry.Snapshot.DeviceIDs); err != nil {
+		return nil, fmt.Errorf("Failed to recreate provisioning profile %s:\n%v\nFailed to restore the original profile, the snapshot is kept in the journal (%s) to be resumed by a later run:\n%v", entry.Snapshot.Name, createErr, j.path, err)
+	}
+
+	if err := j.finish(index); err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("Failed to recreate provisioning profile %s, the original profile was restored:\n%v", entry.Snapshot.Name, createErr)
+}
+
+// Resume finishes the profile regenerations interrupted in a previous run
+func (j *Journal) Resume(client *appstoreconnect.Client) error {
+	if len(j.Entries) == 0 {
+		return nil
+	}
+
+	log.Printf("")
+	log.Infof("Resuming %d interrupted provisioning profile regeneration(s) from journal: %s", len(j.Entries), j.path)
+
+	for len(j.Entries) > 0 {
+		entry := j.Entries[0]
+		log.Printf("Provisioning profile: %s (%s), state: %s", entry.Snapshot.Name, entry.Snapshot.UUID, entry.State)
+
+		if entry.State == JournalSnapshotted {
+			// The original profile may or may not be deleted, deleting an already deleted profile succeeds
+			if err := DeleteProfile(client, entry.Snapshot.ID); err != nil {
+				return fmt.Errorf("Failed to delete provisioning profile %s\n%v", entry.Snapshot.Name, err)
+			}
+			if err := j.update(0, JournalDeleted); err != nil {
+				return err
+			}
+		}
+
+		profile, err := j.complete(client, 0)
+		if err != nil {
+			return err
+		}
+		log.Donef("Provisioning profile %s (%s) successfully created on Apple Deveper Portal", profile.Attributes.Name, profile.Attributes.UUID)
+	}
+
+	return nil
+}
+
+// Regenerate snapshots the profile to the journal, deletes it and creates it again.
+// On failure the creation is retried, then the original profile is restored from the snapshot.
+func (j *Journal) Regenerate(client *appstoreconnect.Client, regeneration ProfileRegeneration) (*appstoreconnect.Profile, error) {
+	originalDevices, err := GetDevices(client, &regeneration.Profile)
+	if err != nil {
+		return nil, err
+	}
+	var originalDeviceIDs []string
+	for _, originalDevice := range originalDevices {
+		originalDeviceIDs = append(originalDeviceIDs, originalDevice.ID)
+	}
+
+	// The snapshot records every certificate of the original profile, including the expired ones the Developer Portal rejects
+	originalCertificates, err := ListProfileCertificates(client, &regeneration.Profile)
+	if err != nil {
+		return nil, err
+	}
+	var originalCertificateIDs []string
+	for _, originalCertificate := range originalCertificates {
+		originalCertificateIDs = append(originalCertificateIDs, originalCertificate.ID)
+	}
+
+	index, err := j.begin(JournalEntry{
+		State: JournalSnapshotted,
+		Snapshot: ProfileSnapshot{
+			ID:               regeneration.Profile.ID,
+			Name:             regeneration.Profile.Attributes.Name,
+			UUID:             regeneration.Profile.Attributes.UUID,
+			ProfileType:      regeneration.Profile.Attributes.ProfileType,
+			BundleIDID:       regeneration.BundleID.ID,
+			BundleIdentifier: regeneration.BundleID.Attributes.Identifier,
+			CertificateIDs:   originalCertificateIDs,
+			DeviceIDs:        originalDeviceIDs,
+			ProfileContent:   regeneration.Profile.Attributes.ProfileContent,
+		},
+		TargetDeviceIDs:      regeneration.DeviceIDs,
+		TargetCertificateIDs: regeneration.CertificateIDs,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Deleting original provisioning profile on Apple Developer Portal")
+	if err := DeleteProfile(client, regeneration.Profile.ID); err != nil {
+		// A lost response does not mean the profile was not deleted, the snapshot is dropped only if the profile still exists
+		exists, eerr := profileExists(client, regeneration.Profile.ID)
+		if eerr != nil {
+			return nil, fmt.Errorf("Failed to delete provisioning profile %s:\n%v\nFailed to check whether the profile still exists, the snapshot is kept in the journal (%s) to be resumed by a later run:\n%v", regeneration.Profile.Attributes.Name, err, j.path, eerr)
+		}
+		if exists {
+			if ferr := j.finish(index); ferr != nil {
+				log.Warnf("%v", ferr)
+			}
+			return nil, err
+		}
+		log.Warnf("Deleting provisioning profile %s failed, but the profile is deleted:\n%v", regeneration.Profile.Attributes.Name, err)
+	}
+	if err := j.update(index, JournalDeleted); err != nil {
+		return nil, err
+	}
+
+	return j.complete(client, index)
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/appstoreconnect"
+)
+
+func openTestJournal(t *testing.T, entries ...JournalEntry) (*Journal, func()) {
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	journal := &Journal{path: filepath.Join(dir, "journal.json"), Entries: entries}
+	if err := journal.save(); err != nil {
+		t.Fatal(err)
+	}
+	return journal, func() { os.RemoveAll(dir) }
+}
+
+func assertJournalEmpty(t *testing.T, journal *Journal) {
+	if len(journal.Entries) != 0 {
+		t.Errorf("Entries = %+v, want none", journal.Entries)
+	}
+	if _, err := os.Stat(journal.path); !os.IsNotExist(err) {
+		t.Errorf("journal file exists, want removed")
+	}
+}
+
+func TestJournal_Resume_AlreadyDeleted(t *testing.T) {
+	portal := newFakePortal()
+	journal, cleanup := openTestJournal(t, JournalEntry{
+		State: JournalSnapshotted,
+		Snapshot: ProfileSnapshot{
+			ID:             "original",
+			Name:           "Sample Development",
+			ProfileType:    "IOS_APP_DEVELOPMENT",
+			BundleIDID:     "bundle",
+			CertificateIDs: []string{"expired", "valid"},
+			DeviceIDs:      []string{"device-1"},
+		},
+		TargetDeviceIDs:      []string{"device-1", "device-2"},
+		TargetCertificateIDs: []string{"valid"},
+	})
+	defer cleanup()
+
+	if err := journal.Resume(portal.client()); err != nil {
+		t.Fatalf("Resume() error = %v", err)
+	}
+
+	if len(portal.created) != 1 {
+		t.Fatalf("created %d profiles, want 1", len(portal.created))
+	}
+	if got, want := portal.created[0].DeviceIDs, []string{"device-1", "device-2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("created profile devices = %v, want %v", got, want)
+	}
+	assertJournalEmpty(t, journal)
+}
+
+func testRegeneration(t *testing.T, portal *fakePortal) ProfileRegeneration {
+	return ProfileRegeneration{
+		Profile: portal.profile(t, "original"),
+		BundleID: appstoreconnect.BundleID{
+			ID:         "bundle",
+			Attributes: appstoreconnect.BundleIDAttributes{Identifier: "io.bitrise.sample"},
+		},
+		CertificateIDs: []string{"valid"},
+		DeviceIDs:      []string{"device-1", "device-2"},
+	}
+}
+
+func originalProfile() fakeProfile {
+	return fakeProfile{
+		ID:             "original",
+		Name:           "Sample Development",
+		UUID:           "original-uuid",
+		ProfileType:    appstoreconnect.IOSAppDevelopment,
+		BundleIDID:     "bundle",
+		CertificateIDs: []string{"expired", "valid"},
+		DeviceIDs:      []string{"device-1"},
+	}
+}
+
+func TestJournal_Regenerate_DeleteFailures(t *testing.T) {
+	t.Run("profile still exists", func(t *testing.T) {
+		portal := newFakePortal(originalProfile())
+		portal.deleteFailure = true
+		journal, cleanup := openTestJournal(t)
+		defer cleanup()
+
+		if _, err := journal.Regenerate(portal.client(), testRegeneration(t, portal)); err == nil {
+			t.Fatalf("Regenerate() error = nil, want delete error")
+		}
+		if _, ok := portal.profiles["original"]; !ok {
+			t.Errorf("original profile deleted, want kept")
+		}
+		if len(portal.created) != 0 {
+			t.Errorf("created %d profiles, want none", len(portal.created))
+		}
+		assertJournalEmpty(t, journal)
+	})
+
+	t.Run("response lost after the deletion", func(t *testing.T) {
+		portal := newFakePortal(originalProfile())
+		portal.dropDeleteResponse = true
+		journal, cleanup := openTestJournal(t)
+		defer cleanup()
+
+		profile, err := journal.Regenerate(portal.client(), testRegeneration(t, portal))
+		if err != nil {
+			t.Fatalf("Regenerate() error = %v", err)
+		}
+		if got, want := portal.profiles[profile.ID].DeviceIDs, []string{"device-1", "device-2"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("regenerated profile devices = %v, want %v", got, want)
+		}
+		assertJournalEmpty(t, journal)
+	})
+}
+
+func TestJournal_Regenerate(t *testing.T) {
+	profileCreateRetryDelay = 0
+
+	tests := []struct {
+		name             string
+		createFailures   int
+		wantErr          bool
+		wantCreated      [][]string
+		wantCertificates []string
+		wantEntries      int
+	}{
+		{
+			name:             "regenerated",
+			wantCreated:      [][]string{{"device-1", "device-2"}},
+			wantCertificates: []string{"valid"},
+		},
+		{
+			name:             "creation fails, original restored",
+			createFailures:   profileCreateAttempts,
+			wantErr:          true,
+			wantCreated:      [][]string{{"device-1"}},
+			wantCertificates: []string{"valid"},
+		},
+		{
+			name:           "creation and restore fail",
+			createFailures: 2 * profileCreateAttempts,
+			wantErr:        true,
+			wantEntries:    1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			portal := newFakePortal(originalProfile())
+			portal.createFailures = tt.createFailures
+			journal, cleanup := openTestJournal(t)
+			defer cleanup()
+
+			_, err := journal.Regenerate(portal.client(), testRegeneration(t, portal))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Regenerate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if _, ok := portal.profiles["original"]; ok {
+				t.Errorf("original profile kept, want deleted")
+			}
+			if len(portal.created) != len(tt.wantCreated) {
+				t.Fatalf("created %d profiles, want %d", len(portal.created), len(tt.wantCreated))
+			}
+			for i, created := range portal.created {
+				if !reflect.DeepEqual(created.DeviceIDs, tt.wantCreated[i]) {
+					t.Errorf("created profile devices = %v, want %v", created.DeviceIDs, tt.wantCreated[i])
+				}
+				if !reflect.DeepEqual(created.CertificateIDs, tt.wantCertificates) {
+					t.Errorf("created profile certificates = %v, want %v", created.CertificateIDs, tt.wantCertificates)
+				}
+			}
+
+			reopened, err := OpenJournal(journal.path)
+			if err != nil {
+				t.Fatalf("OpenJournal() error = %v", err)
+			}
+			if len(reopened.Entries) != tt.wantEntries {
+				t.Fatalf("reopened journal has %d entries, want %d", len(reopened.Entries), tt.wantEntries)
+			}
+			if tt.wantEntries == 0 {
+				return
+			}
+
+			entry := reopened.Entries[0]
+			if entry.State != JournalDeleted {
+				t.Errorf("State = %s, want %s", entry.State, JournalDeleted)
+			}
+			if got, want := entry.Snapshot.CertificateIDs, []string{"expired", "valid"}; !reflect.DeepEqual(got, want) {
+				t.Errorf("Snapshot.CertificateIDs = %v, want %v", got, want)
+			}
+
+			// A later run resumes the regeneration
+			if err := reopened.Resume(portal.client()); err != nil {
+				t.Fatalf("Resume() error = %v", err)
+			}
+			if len(portal.created) != 1 || !reflect.DeepEqual(portal.created[0].DeviceIDs, []string{"device-1", "device-2"}) {
+				t.Errorf("created profiles = %+v, want the regenerated profile", portal.created)
+			}
+			assertJournalEmpty(t, reopened)
+		})
+	}
+}
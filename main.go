@@ -1,20 +1,22 @@
 package main
 
 import (
-	"bytes"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"os"
-	"os/exec"
-	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/birmacher/steps-register-ios-device/device"
+	"github.com/birmacher/steps-register-ios-device/xcarchive"
 	"github.com/bitrise-io/go-steputils/stepconf"
 	"github.com/bitrise-io/go-steputils/tools"
 	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-io/go-xcode/exportoptions"
+	"github.com/bitrise-io/go-xcode/profileutil"
 	"github.com/bitrise-steplib/steps-deploy-to-itunesconnect-deliver/appleauth"
 	"github.com/bitrise-steplib/steps-deploy-to-itunesconnect-deliver/devportalservice"
 	"github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/appstoreconnect"
@@ -50,7 +52,7 @@ func setupStepConfigs() (Config, error) {
 	return stepConf, nil
 }
 
-func setupAppStoreConnectAPIClient(config Config) (*appstoreconnect.Client, error) {
+func setupAppStoreConnectAPIClient(config Config) (*appstoreconnect.Client, *devportalservice.AppleDeveloperConnection, error) {
 	// Creating AppstoreConnectAPI client
 	log.Infof("Setup App Store Connect API connection")
 
@@ -60,7 +62,7 @@ func setupAppStoreConnectAPIClient(config Config) (*appstoreconnect.Client, erro
 		APIKeyPath: string(config.APIKeyPath),
 	}
 	if err := authInputs.Validate(); err != nil {
-		return nil, fmt.Errorf("Failed to validate App Store Connect API inputs:\n%v", err)
+		return nil, nil, fmt.Errorf("Failed to validate App Store Connect API inputs:\n%v", err)
 	}
 
 	// Authentication sources
@@ -95,7 +97,7 @@ func setupAppStoreConnectAPIClient(config Config) (*appstoreconnect.Client, erro
 	// Setup configs with newly acquired bitrise account, or fall back to step inputs
 	authConfig, err := appleauth.Select(appleDeveloperPortalConnection, authSources, authInputs)
 	if err != nil {
-		return nil, fmt.Errorf("Failed to configure App Store Connect API authentication:\n%v", err)
+		return nil, nil, fmt.Errorf("Failed to configure App Store Connect API authentication:\n%v", err)
 	}
 
 	// Setup connection
@@ -103,12 +105,126 @@ func setupAppStoreConnectAPIClient(config Config) (*appstoreconnect.Client, erro
 	client.EnableDebugLogs = false
 
 	log.Donef("Successfully setup connection to Apple Developer Portal")
-	return client, nil
+	return client, appleDeveloperPortalConnection, nil
+}
+
+func collectTestDevices(connection *devportalservice.AppleDeveloperConnection) []device.Device {
+	if connection == nil {
+		log.Warnf("Bitrise test devices are not available: Apple Developer Portal connection not found")
+		return nil
+	}
+
+	for _, testDevice := range connection.DuplicatedTestDevices {
+		log.Warnf("Test device %s (%s) is registered more than once on bitrise.io, please remove the duplicate", testDevice.Title, testDevice.DeviceID)
+	}
+
+	var devices []device.Device
+	for _, testDevice := range connection.TestDevices {
+		devices = append(devices, device.NewDeviceFromTestDevice(testDevice))
+	}
+	log.Printf("%d test device(s) found on bitrise.io", len(devices))
+
+	return devices
+}
+
+func collectDevices(config Config, connection *devportalservice.AppleDeveloperConnection) ([]device.Device, error) {
+	var devices []device.Device
+
+	if config.DeviceUDID != "" {
+		devices = append(devices, device.Device{
+			Name:     config.DeviceName,
+			UDID:     config.DeviceUDID,
+			Platform: config.DevicePlatform,
+			Model:    config.DeviceModel,
+		})
+	}
+
+	if config.DevicesFile != "" {
+		fileDevices, err := device.ParseDevicesFile(config.DevicesFile, config.DevicePlatform)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("%d device(s) found in devices file: %s", len(fileDevices), config.DevicesFile)
+
+		devices = append(devices, fileDevices...)
+	}
+
+	if config.RegisterTestDevices {
+		devices = append(devices, collectTestDevices(connection)...)
+	}
+
+	if len(devices) == 0 {
+		return nil, fmt.Errorf("No device to register: provide device_udid, devices_file or enable register_test_devices")
+	}
+
+	devices, duplicated := device.Deduplicate(devices)
+	for _, d := range duplicated {
+		log.Warnf("Device %s (%s) is listed more than once, skipping the duplicate", d.Name, d.UDID)
+	}
+
+	return devices, nil
+}
+
+func exportRemainingDeviceSlots(quota device.Quota) error {
+	for _, class := range device.DeviceClasses {
+		key := "BITRISE_DEVICE_SLOTS_REMAINING_" + string(class)
+		if err := tools.ExportEnvironmentWithEnvman(key, strconv.Itoa(quota.Remaining(class))); err != nil {
+			return fmt.Errorf("Failed to export %s\n%v", key, err)
+		}
+	}
+	return nil
+}
+
+func exportRegistrationResults(results []device.Result) error {
+	counts := device.CountByStatus(results)
+	outputs := map[string]string{
+		"BITRISE_DEVICES_REGISTERED": strconv.Itoa(counts[device.StatusRegistered]),
+		"BITRISE_DEVICES_REENABLED":  strconv.Itoa(counts[device.StatusReEnabled]),
+		"BITRISE_DEVICES_SKIPPED":    strconv.Itoa(counts[device.StatusSkipped]),
+		"BITRISE_DEVICES_INVALID":    strconv.Itoa(counts[device.StatusInvalid]),
+		"BITRISE_DEVICES_FAILED":     strconv.Itoa(counts[device.StatusFailed]),
+	}
+
+	resultsJSON, err := json.Marshal(results)
+	if err != nil {
+		return fmt.Errorf("Failed to serialize device registration results\n%v", err)
+	}
+	outputs["BITRISE_DEVICE_REGISTRATION_RESULTS"] = string(resultsJSON)
+
+	for key, value := range outputs {
+		if err := tools.ExportEnvironmentWithEnvman(key, value); err != nil {
+			return fmt.Errorf("Failed to export %s\n%v", key, err)
+		}
+	}
+	return nil
+}
+
+// checkFailurePolicy returns an error if the invalid or failed device registrations violate the fail_on policy:
+// `any` fails if at least one device was not registered, `all` fails if none of the devices were registered, `none` never fails.
+func checkFailurePolicy(results []device.Result, failOn string) error {
+	counts := device.CountByStatus(results)
+	failed := counts[device.StatusInvalid] + counts[device.StatusFailed]
+
+	switch failOn {
+	case "none":
+		return nil
+	case "all":
+		if failed > 0 && failed == len(results) {
+			return fmt.Errorf("Failed to register all of the %d device(s)", len(results))
+		}
+	default:
+		if failed > 0 {
+			return fmt.Errorf("Failed to register %d of the %d device(s)", failed, len(results))
+		}
+	}
+
+	return nil
 }
 
 func logErrorAndExitIfAny(err error) {
 	if err != nil {
 		log.Errorf("%v", err)
+		writeFailureReport(err)
 		os.Exit(1)
 	}
 }
@@ -117,179 +233,383 @@ func main() {
 	config, err := setupStepConfigs()
 	logErrorAndExitIfAny(err)
 
-	client, err := setupAppStoreConnectAPIClient(config)
+	runReport.path = reportPath(config)
+	runReport.Mode = config.Mode
+	runReport.DryRun = config.DryRun
+	runReport.StartPhase("setup")
+
+	client, connection, err := setupAppStoreConnectAPIClient(config)
 	logErrorAndExitIfAny(err)
 
-	err = device.RegisterDevices(client, []device.Device{
-		{
-			Name:     config.DeviceName,
-			UDID:     config.DeviceUDID,
-			Platform: config.DevicePlatform,
-		},
+	journal, err := OpenJournal(config.ProfileJournalPath)
+	logErrorAndExitIfAny(err)
+
+	if config.DryRun {
+		if len(journal.Entries) > 0 {
+			log.Warnf("Dry run: %d interrupted provisioning profile regeneration(s) in journal %s are not resumed", len(journal.Entries), config.ProfileJournalPath)
+		}
+	} else {
+		err = journal.Resume(client)
+		logErrorAndExitIfAny(err)
+	}
+
+	if config.Mode == "decommission" {
+		runReport.StartPhase("decommission")
+		err = runDecommission(client, journal, config)
+		logErrorAndExitIfAny(err)
+
+		exitWithReport()
+	}
+
+	runReport.StartPhase("device registration")
+	devices, err := collectDevices(config, connection)
+	logErrorAndExitIfAny(err)
+
+	registeredDevices, err := device.ListAllDevices(client)
+	logErrorAndExitIfAny(err)
+
+	err = device.CheckQuota(device.NewQuota(registeredDevices), devices, registeredDevices, config.DeviceLimitPolicy == "fail" && !config.DryRun)
+	logErrorAndExitIfAny(err)
+
+	results, err := device.RegisterDevices(client, device.NewDeviceIndex(registeredDevices), devices, device.RegisterOptions{
+		RenameExisting: config.RenameExisting,
+		Concurrency:    config.Concurrency,
+		DryRun:         config.DryRun,
 	})
 	logErrorAndExitIfAny(err)
+	runReport.Devices = results
+
+	device.PrintSummary(results)
+
+	err = exportRegistrationResults(results)
+	logErrorAndExitIfAny(err)
+
+	err = checkFailurePolicy(results, config.FailOn)
+	logErrorAndExitIfAny(err)
+
+	devices = device.SucceededDevices(results)
+
+	if !config.DryRun {
+		registeredDevices, err = device.ListAllDevices(client)
+		logErrorAndExitIfAny(err)
+	}
+
+	err = exportRemainingDeviceSlots(device.NewQuota(registeredDevices))
+	logErrorAndExitIfAny(err)
+
+	plan := Plan{Devices: results}
+	// dryRunPlan collects the profiles a dry run would change
+	var dryRunPlan *Plan
+	if config.DryRun {
+		dryRunPlan = &plan
+	}
+
+	if config.XcarchivePath == "" {
+		if config.DryRun {
+			runReport.Plan = &plan
+			plan.Print()
+			err = exportPlan(plan)
+			logErrorAndExitIfAny(err)
+		}
+
+		log.Printf("")
+		log.Printf("Xcarchive path not provided, skipping provisioning profile regeneration")
+		exitWithReport()
+	}
 
 	// This will need to be moved out from this step
 	// for the experiment I'll leave it here as it's easier this way
 
-	// find all provisioning profiles
-	var profilePaths = []string{}
-	err = filepath.Walk(config.XcarchivePath,
-		func(path string, info os.FileInfo, err error) error {
-			if err != nil {
-				return err
-			}
-
-			if strings.HasSuffix(path, "embedded.mobileprovision") {
-				profilePaths = append(profilePaths, path)
-			}
-			return nil
-		})
+	runReport.StartPhase("profile regeneration")
+	archive, err := xcarchive.NewArchive(config.XcarchivePath)
 	if err != nil {
 		logErrorAndExitIfAny(fmt.Errorf("Failed to read Xcarchive file: %s\n%v", config.XcarchivePath, err))
 	}
 
-	profileNames := make(map[string]string)
-	var distributionType appstoreconnect.ProfileType = ""
+	bundleIDToExport, err := BundleIDToExport(config.BundleIDToExport, archive)
+	logErrorAndExitIfAny(err)
+	log.Printf("Bundle ID to export: %s", bundleIDToExport)
 
-	xcarchiveInfoPlist := path.Join(config.XcarchivePath, "Info.plist")
-	teamID, err := GetValueForKeyInPlist(xcarchiveInfoPlist, ":ApplicationProperties:Team")
-	if err != nil {
-		logErrorAndExitIfAny(fmt.Errorf("Failed to read Xcarchive's Info.plist file at path: %s\n%v", xcarchiveInfoPlist, err))
+	membership := ProfileMembership{
+		Strategy:      config.ProfileDeviceMembership,
+		Index:         device.NewDeviceIndex(registeredDevices),
+		Devices:       devices,
+		ExplicitUDIDs: splitUDIDs(config.ProfileDeviceUDIDs),
 	}
-
-	signingIdentity, err := GetValueForKeyInPlist(xcarchiveInfoPlist, ":ApplicationProperties:SigningIdentity")
-	if err != nil {
-		logErrorAndExitIfAny(fmt.Errorf("Failed to read Xcarchive's Info.plist file at path: %s\n%v", xcarchiveInfoPlist, err))
+	if membership.Strategy == MembershipExplicit && len(membership.ExplicitUDIDs) == 0 {
+		logErrorAndExitIfAny(fmt.Errorf("No device listed in profile_device_udids, required by the explicit profile_device_membership"))
 	}
 
+	profileNames := make(map[string]string)
+	// profilesToInstall holds the up to date Developer Portal profile of each bundle
+	profilesToInstall := make(map[string]appstoreconnect.Profile)
+	// embeddedUUIDs and profileActions describe the profiles in the run report
+	embeddedUUIDs := make(map[string]string)
+	profileActions := make(map[string]string)
+	// regeneratedProfiles maps the original UUID of the profiles regenerated in this run to the new profiles
+	regeneratedProfiles := make(map[string]appstoreconnect.Profile)
+	var distributionType appstoreconnect.ProfileType = ""
+
+	teamID := archive.TeamID()
+	signingIdentity := archive.SigningIdentity()
+
 	// list profiles
-	for _, profilePath := range profilePaths {
+	for _, bundle := range archive.Bundles() {
+		if bundle.Profile == nil {
+			continue
+		}
+
 		log.Printf("")
-		log.Infof("Provisioning profile located at: %s", profilePath)
+		log.Infof("Provisioning profile located at: %s", bundle.ProfilePath)
 
-		// locate Info.plist
-		infoPlistPath := path.Join(path.Dir(profilePath), "Info.plist")
-		bundleIdentifier, err := GetValueForKeyInPlist(infoPlistPath, ":CFBundleIdentifier")
-		if err != nil {
-			logErrorAndExitIfAny(fmt.Errorf("Failed to read Info.plist file at path: %s\n%v", infoPlistPath, err))
-		}
+		bundleIdentifier := bundle.BundleID
+		embeddedProfile := bundle.Profile
 
-		// get name
-		name, err := GetValueForKeyInProvisioningProfile(profilePath, "Name")
-		if err != nil {
-			logErrorAndExitIfAny(fmt.Errorf("%s", name))
-		}
+		name := embeddedProfile.Name
 		profileNames[bundleIdentifier] = name
+		embeddedUUIDs[bundleIdentifier] = embeddedProfile.UUID
 
-		// get platform
-		platform, err := GetValueForKeyInProvisioningProfile(profilePath, "Platform")
-		if err != nil {
-			logErrorAndExitIfAny(fmt.Errorf("%s", platform))
-		}
+		isMacOS := embeddedProfile.HasPlatform("OSX")
 
-		if !strings.Contains(platform, "iOS") {
-			log.Warnf("Provisioning profile platform is not iOS. Skipping...")
-			continue
-		}
+		var profile *appstoreconnect.Profile
+		// Developer ID profiles are not limited to a list of devices
+		isDeveloperID := isMacOS && !embeddedProfile.HasProvisionedDevices() && embeddedProfile.ProvisionsAllDevices
 
-		// get device UUIDs
-		_, err = GetValueForKeyInProvisioningProfile(profilePath, ":ProvisionedDevices")
-		if err != nil {
-			logErrorAndExitIfAny(fmt.Errorf("Cannot resign with provisioning profile type: AppStore, or Enterprise provisioning profile detected."))
-		}
+		if isDeveloperID {
+			if bundleIdentifier == bundleIDToExport {
+				distributionType = appstoreconnect.MacAppDirect
+			}
 
-		// get distribution type for the file to export
-		if bundleIdentifier == config.BundleIDToExport {
-			distributionBoolenFlag, err := GetValueForKeyInProvisioningProfile(profilePath, ":Entitlements:get-task-allow")
-			if err != nil {
-				logErrorAndExitIfAny(fmt.Errorf("%s", distributionBoolenFlag))
+			profile, err = FindProfileWithUUID(client, regeneratedProfiles, embeddedProfile.UUID, name, bundleIdentifier, appstoreconnect.MacAppDirect)
+			logErrorAndExitIfAny(err)
+			profilesToInstall[bundleIdentifier] = *profile
+		} else if !embeddedProfile.HasProvisionedDevices() {
+			if !config.AdHocForDistributionProfiles || isMacOS {
+				logErrorAndExitIfAny(fmt.Errorf("Cannot resign with provisioning profile type: AppStore, or Enterprise provisioning profile detected."))
 			}
 
-			if distributionBoolenFlag == "true" {
-				distributionType = appstoreconnect.IOSAppDevelopment
-			} else {
-				distributionType = appstoreconnect.IOSAppAdHoc
+			log.Printf("AppStore or Enterprise provisioning profile detected, using an ad-hoc provisioning profile instead")
+			profileType := adHocProfileType(*embeddedProfile)
+			if bundleIdentifier == bundleIDToExport {
+				distributionType = profileType
 			}
-		}
 
-		profile, err := FindProfileWithName(client, name)
-		logErrorAndExitIfAny(err)
+			manualProfile, err := useManualProfile(client, membership, profileType, bundleIdentifier, *embeddedProfile, dryRunPlan)
+			logErrorAndExitIfAny(err)
 
-		devicesInProfile, err := GetDevices(client, profile)
-		logErrorAndExitIfAny(err)
+			profileNames[bundleIdentifier] = manualProfile.Name
+			if manualProfile.Profile != nil {
+				profilesToInstall[bundleIdentifier] = *manualProfile.Profile
+			}
+			if manualProfile.Created {
+				profileActions[bundleIdentifier] = ProfileCreated
+			}
+			if manualProfile.Done() {
+				continue
+			}
+			profile = manualProfile.Profile
+		} else {
+			profileType := deviceProfileType(*embeddedProfile)
 
-		deviceFound := false
-		for _, deviceInProfile := range devicesInProfile {
-			if deviceInProfile.Attributes.UDID == config.DeviceUDID {
-				log.Warnf("Device already added to this provisioning profile. Skipping...")
+			// get distribution type for the file to export
+			if bundleIdentifier == bundleIDToExport {
+				distributionType = profileType
+			}
 
-				deviceFound = true
-				break
+			if profileutil.IsXcodeManaged(name) {
+				// Xcode managed profiles can not be deleted and recreated using the API
+				log.Printf("Xcode managed provisioning profile detected, using a manual provisioning profile instead")
+				manualProfile, err := useManualProfile(client, membership, profileType, bundleIdentifier, *embeddedProfile, dryRunPlan)
+				logErrorAndExitIfAny(err)
+
+				profileNames[bundleIdentifier] = manualProfile.Name
+				if manualProfile.Profile != nil {
+					profilesToInstall[bundleIdentifier] = *manualProfile.Profile
+				}
+				if manualProfile.Created {
+					profileActions[bundleIdentifier] = ProfileCreated
+				}
+				if manualProfile.Done() {
+					continue
+				}
+				profile = manualProfile.Profile
+			} else {
+				profile, err = FindProfileWithUUID(client, regeneratedProfiles, embeddedProfile.UUID, name, bundleIdentifier, profileType)
+				logErrorAndExitIfAny(err)
+				profilesToInstall[bundleIdentifier] = *profile
 			}
 		}
-		if deviceFound == true {
+
+		// A profile shared with a previous bundle is regenerated once
+		if regenerated, ok := regeneratedProfiles[embeddedProfile.UUID]; ok && regenerated.ID == profile.ID {
+			log.Printf("Provisioning profile %s is shared with a previous bundle, skipping...", profile.Attributes.Name)
+			profilesToInstall[bundleIdentifier] = *profile
+			profileActions[bundleIdentifier] = ProfileRegenerated
 			continue
 		}
 
-		log.Printf("Attempting to update provisioning profile on Apple Developer Portal: %s", profile.Attributes.Name)
-
-		// BundleID
-		bundleID, err := GetBundleID(client, profile)
+		problems, err := ProfileHealthProblems(client, profile, config.MinProfileDaysValid)
 		logErrorAndExitIfAny(err)
+		for _, problem := range problems {
+			log.Warnf("Provisioning profile has to be recreated: %s", problem)
+		}
+		renew := len(problems) > 0
+
+		var deviceIDs []string
+		var missingDevices []device.Device
+		if isDeveloperID {
+			if !renew {
+				log.Warnf("Developer ID provisioning profile includes every device. Skipping...")
+				continue
+			}
+		} else {
+			devicesInProfile, err := GetDevices(client, profile)
+			logErrorAndExitIfAny(err)
+
+			// Devices
+			deviceIDs, err = membership.DeviceIDs(client, profile, devicesInProfile)
+			logErrorAndExitIfAny(err)
+
+			missingDevices = MissingDevices(devicesForProfileType(membership.Index, devices, profile.Attributes.ProfileType), devicesInProfile)
+			if membership.Strategy == MembershipExplicit {
+				changed := IsExplicitMembershipChanged(devicesInProfile, deviceIDs)
+				if !changed && !renew {
+					log.Warnf("Provisioning profile already includes the listed devices only. Skipping...")
+					continue
+				}
+				if changed {
+					log.Printf("Provisioning profile devices differ from the listed devices")
+				}
+			} else {
+				if len(missingDevices) == 0 && !renew {
+					log.Warnf("All devices already added to this provisioning profile. Skipping...")
+					continue
+				}
+				if len(missingDevices) > 0 {
+					log.Printf("%d device(s) missing from the provisioning profile", len(missingDevices))
+				}
+			}
+		}
 
-		// Certificates
-		certificateIDs, err := GetCertificates(client, profile)
+		regeneration, err := NewProfileRegeneration(client, profile, deviceIDs)
 		logErrorAndExitIfAny(err)
 
-		// Devices
-		deviceIDs, err := GetAllRegisteredDevices(client, profile)
-		logErrorAndExitIfAny(err)
+		if config.DryRun {
+			log.Printf("Dry run: provisioning profile %s would be deleted and recreated", profile.Attributes.Name)
+			plan.Profiles = append(plan.Profiles, NewProfilePlan(*regeneration, missingDevices))
+			regeneratedProfiles[profile.Attributes.UUID] = *profile
+			continue
+		}
 
-		// Delete profile
-		log.Printf("Deleting original provisioning profile on Apple Developer Portal")
-		err = autoprovision.DeleteProfile(client, profile.ID)
-		logErrorAndExitIfAny(err)
+		log.Printf("Attempting to update provisioning profile on Apple Developer Portal: %s", profile.Attributes.Name)
 
-		// Create profile
-		log.Printf("Recreating provisioning profile on Apple Developer Portal")
-		profile, err = autoprovision.CreateProfile(
-			client,
-			profile.Attributes.Name,
-			profile.Attributes.ProfileType,
-			*bundleID,
-			certificateIDs,
-			deviceIDs,
-		)
+		originalUUID := profile.Attributes.UUID
+		profile, err = regeneration.Execute(client, journal)
 		logErrorAndExitIfAny(err)
+		regeneratedProfiles[originalUUID] = *profile
+		profilesToInstall[bundleIdentifier] = *profile
+		profileActions[bundleIdentifier] = ProfileRegenerated
 
 		log.Donef("Provisioning profile %s (%s) successfully created on Apple Deveper Portal", profile.Attributes.Name, profile.Attributes.UUID)
 	}
 
+	if config.DryRun {
+		runReport.Plan = &plan
+		plan.Print()
+		err = exportPlan(plan)
+		logErrorAndExitIfAny(err)
+
+		exitWithReport()
+	}
+
+	if distributionType == "" {
+		logErrorAndExitIfAny(fmt.Errorf("No provisioning profile embedded for the bundle ID to export: %s, the export method can not be determined", bundleIDToExport))
+	}
+
+	runReport.StartPhase("profile installation")
 	log.Printf("")
 	log.Infof("Installing provisioning profiles")
-	for _, profileName := range profileNames {
-		profile, err := FindProfileWithName(client, profileName)
+	for _, bundleIdentifier := range archive.BundleIDs() {
+		profile, ok := profilesToInstall[bundleIdentifier]
+		if !ok {
+			continue
+		}
+
+		installedPath, err := DownloadProvisioningProfile(client, profile)
 		logErrorAndExitIfAny(err)
 
-		err = DownloadProvisioningProfile(client, *profile)
+		action, ok := profileActions[bundleIdentifier]
+		if !ok {
+			action = ProfileUnchanged
+		}
+		profileReport, err := NewProfileReport(client, bundleIdentifier, embeddedUUIDs[bundleIdentifier], action, profile, installedPath)
 		logErrorAndExitIfAny(err)
+		runReport.Profiles = append(runReport.Profiles, profileReport)
 	}
 	log.Donef("Successfully installed provisioning profiles")
 
+	runReport.StartPhase("export options")
 	log.Printf("")
-	xcarchiveExportOptions := XCarxhiveExportOption(config.BundleIDToExport, distributionType.ReadableString(), signingIdentity, profileNames, teamID)
-	if err := tools.ExportEnvironmentWithEnvman("BITRISE_XCARCHIVE_EXPORT_OPTIONS", xcarchiveExportOptions); err != nil {
-		logErrorAndExitIfAny(fmt.Errorf("Failed to export BITRISE_XCARCHIVE_EXPORT_OPTIONS\n%v", err))
+	exportOptions := ExportOptions{
+		DistributionBundleIdentifier: bundleIDToExport,
+		Method:                       exportMethod(distributionType),
+		TeamID:                       teamID,
+		SigningCertificate:           signingIdentity,
+		ProvisioningProfiles:         profileNames,
+		Manifest: exportoptions.Manifest{
+			AppURL:           config.ExportManifestAppURL,
+			DisplayImageURL:  config.ExportManifestDisplayImageURL,
+			FullSizeImageURL: config.ExportManifestFullSizeImageURL,
+		},
 	}
+
+	if config.OTABaseURL != "" {
+		switch exportOptions.Method {
+		case exportoptions.MethodAdHoc, exportoptions.MethodEnterprise, exportoptions.MethodDevelopment:
+			ota := NewOTAInstall(config, archive)
+			exportOptions.Manifest = ota.Manifest(exportOptions.Manifest)
+
+			err = WriteOTAInstall(ota, exportOptions.Manifest, config.DeployDir)
+			logErrorAndExitIfAny(err)
+			log.Donef("OTA install manifest and page written to %s", config.DeployDir)
+		default:
+			log.Warnf("Over-the-air installation is not available for %s export method, skipping OTA install manifest", exportOptions.Method)
+		}
+	}
+
+	runReport.ExportMethod = exportOptions.Method
+	exportOptionsPath, err := exportExportOptions(exportOptions, config)
+	logErrorAndExitIfAny(err)
 	log.Donef("Xcarchive export options exported to BITRISE_XCARCHIVE_EXPORT_OPTIONS environment variable")
+	if exportOptionsPath != "" {
+		log.Donef("Xcarchive export options written to %s (BITRISE_XCARCHIVE_EXPORT_OPTIONS_PATH)", exportOptionsPath)
+	}
+	runReport.ExportOptionsPath = exportOptionsPath
 
-	os.Exit(0)
+	exitWithReport()
+}
+
+// BundleIDToExport returns the bundle ID to export from the archive.
+// It defaults to the main application, an explicit bundle ID has to be one of the archive's bundles.
+func BundleIDToExport(bundleID string, archive xcarchive.Archive) (string, error) {
+	if bundleID == "" {
+		return archive.MainBundleID(), nil
+	}
+
+	bundleIDs := archive.BundleIDs()
+	for _, id := range bundleIDs {
+		if id == bundleID {
+			return bundleID, nil
+		}
+	}
+	return "", fmt.Errorf("Bundle ID to export: %s not found in the Xcarchive, available bundle IDs:\n- %s", bundleID, strings.Join(bundleIDs, "\n- "))
 }
 
 func GetBundleID(client *appstoreconnect.Client, profile *appstoreconnect.Profile) (*appstoreconnect.BundleID, error) {
 	bundleIDResponse, err := client.Provisioning.BundleID(profile.Relationships.BundleID.Links.Related)
-	logErrorAndExitIfAny(err)
+	if err != nil {
+		return nil, err
+	}
 
 	return autoprovision.FindBundleID(client, bundleIDResponse.Data.Attributes.Identifier)
 }
@@ -321,31 +641,42 @@ func GetDevices(client *appstoreconnect.Client, profile *appstoreconnect.Profile
 	return devices, nil
 }
 
-func GetCertificates(client *appstoreconnect.Client, profile *appstoreconnect.Profile) ([]string, error) {
-	var certificateIDs []string
-	var nextPageURL string
-
-	for {
-		response, err := client.Provisioning.Certificates(
-			profile.Relationships.Certificates.Links.Related,
-			&appstoreconnect.PagingOptions{
-				Limit: 20,
-				Next:  nextPageURL,
-			},
-		)
-		if err != nil {
-			return []string{}, err
+func MissingDevices(devices []device.Device, devicesInProfile []appstoreconnect.Device) []device.Device {
+	var missing []device.Device
+	for _, d := range devices {
+		found := false
+		for _, deviceInProfile := range devicesInProfile {
+			if devportalservice.IsEqualUDID(deviceInProfile.Attributes.UDID, d.UDID) {
+				found = true
+				break
+			}
 		}
-
-		var certificates []appstoreconnect.Certificate = response.Data
-		for _, certificate := range certificates {
-			certificateIDs = append(certificateIDs, certificate.ID)
+		if !found {
+			missing = append(missing, d)
 		}
+	}
 
-		nextPageURL = response.Links.Next
-		if nextPageURL == "" {
-			break
+	return missing
+}
+
+func GetCertificates(client *appstoreconnect.Client, profile *appstoreconnect.Profile) ([]string, error) {
+	certificates, err := ListProfileCertificates(client, profile)
+	if err != nil {
+		return []string{}, err
+	}
+
+	var certificateIDs []string
+	for _, certificate := range certificates {
+		// An expired certificate can not be included in the recreated profile
+		if expired, expiry := isCertificateExpired(certificate); expired {
+			log.Warnf("Certificate %s expired at %s, it is left out from the provisioning profile", certificate.Attributes.DisplayName, expiry)
+			continue
 		}
+		certificateIDs = append(certificateIDs, certificate.ID)
+	}
+
+	if len(certificateIDs) == 0 {
+		return []string{}, fmt.Errorf("No valid certificate of provisioning profile %s found on Apple Developer Portal", profile.Attributes.Name)
 	}
 
 	return certificateIDs, nil
@@ -354,16 +685,13 @@ func GetCertificates(client *appstoreconnect.Client, profile *appstoreconnect.Pr
 func GetAllRegisteredDevices(client *appstoreconnect.Client, profile *appstoreconnect.Profile) ([]string, error) {
 	var deviceIDs []string
 
-	devices, err := autoprovision.ListDevices(client, "", appstoreconnect.IOSDevice)
+	devices, err := autoprovision.ListDevices(client, "", profileDevicePlatform(profile.Attributes.ProfileType))
 	if err != nil {
 		return []string{}, err
 	}
 
 	for _, device := range devices {
-		if strings.HasPrefix(string(profile.Attributes.ProfileType), "TVOS") && device.Attributes.DeviceClass != "APPLE_TV" {
-			continue
-		} else if strings.HasPrefix(string(profile.Attributes.ProfileType), "IOS") &&
-			string(device.Attributes.DeviceClass) != "IPHONE" && string(device.Attributes.DeviceClass) != "IPAD" && string(device.Attributes.DeviceClass) != "IPOD" && string(device.Attributes.DeviceClass) != "APPLE_WATCH" {
+		if !isDeviceCompatibleWithProfile(profile.Attributes.ProfileType, device) {
 			continue
 		}
 		deviceIDs = append(deviceIDs, device.ID)
@@ -372,129 +700,145 @@ func GetAllRegisteredDevices(client *appstoreconnect.Client, profile *appstoreco
 	return deviceIDs, nil
 }
 
-func GetValueForKeyInProvisioningProfile(filePath string, key string) (string, error) {
-	var out bytes.Buffer
-	cmd := exec.Command("security", "cms", "-D", "-i", filePath)
-	cmd.Stdout = &out
-	err := cmd.Run()
-	if err != nil {
-		return "", err
-	}
-
-	var tmpFilePath string = os.TempDir() + "resign-profile.plist"
-	os.Remove(tmpFilePath)
+// DownloadProvisioningProfile installs the profile and returns the path of the installed file
+func DownloadProvisioningProfile(client *appstoreconnect.Client, profile appstoreconnect.Profile) (string, error) {
+	log.Printf("Installing provisioning profile: %s (%s)", profile.Attributes.Name, profile.Attributes.UUID)
 
-	ioutil.WriteFile(filepath.Join(tmpFilePath), out.Bytes(), 0644)
-	defer func() {
-		os.Remove(tmpFilePath)
-	}()
+	profilesDir := filepath.Join(os.Getenv("HOME"), "Library/MobileDevice/Provisioning Profiles")
+	if err := os.MkdirAll(profilesDir, 0700); err != nil {
+		return "", fmt.Errorf("Failed to create provisioning profiles directory: %s\n%v", profilesDir, err)
+	}
 
-	return GetValueForKeyInPlist(tmpFilePath, key)
-}
+	// macOS profiles are installed with the .provisionprofile extension, iOS and tvOS profiles with .mobileprovision
+	ext := ".mobileprovision"
+	if isMacProfileType(profile.Attributes.ProfileType) || profile.Attributes.Platform == appstoreconnect.MacOS {
+		ext = ".provisionprofile"
+	}
 
-func GetValueForKeyInPlist(filePath string, key string) (string, error) {
-	cmd := exec.Command("/usr/libexec/PlistBuddy", "-c", "Print "+key, filePath)
-	bytes, err := cmd.CombinedOutput()
-	return strings.TrimSpace(string(bytes)), err
+	pth := filepath.Join(profilesDir, profile.Attributes.UUID+ext)
+	if err := ioutil.WriteFile(pth, profile.Attributes.ProfileContent, 0600); err != nil {
+		return "", fmt.Errorf("Failed to install profile %s (%s)\n%v", profile.Attributes.Name, profile.Attributes.UUID, err)
+	}
+	return pth, nil
 }
 
-func DownloadProvisioningProfile(client *appstoreconnect.Client, profile appstoreconnect.Profile) error {
-	log.Printf("Installing provisioning profile: %s (%s)", profile.Attributes.Name, profile.Attributes.UUID)
+// FindProfileWithUUID returns the Developer Portal profile with the UUID of an embedded profile.
+// A profile shared by more bundles is regenerated for the first one, the regenerated profiles of the run are looked up by their original UUID.
+// Once the profile is regenerated its UUID changes, so if the UUID is not found,
+// the profile with the same name and type, and the bundle ID or a matching wildcard bundle ID is returned. More than one such profile is an error.
+func FindProfileWithUUID(client *appstoreconnect.Client, regeneratedProfiles map[string]appstoreconnect.Profile, uuid, name, bundleIdentifier string, profileType appstoreconnect.ProfileType) (*appstoreconnect.Profile, error) {
+	if profile, ok := regeneratedProfiles[uuid]; ok {
+		return &profile, nil
+	}
 
-	err := autoprovision.WriteProfile(profile)
+	profiles, err := ListProfilesWithType(client, profileType)
 	if err != nil {
-		return fmt.Errorf("Failed to install profile %s (%s)\n%v", profile.Attributes.Name, profile.Attributes.UUID, err)
+		return nil, fmt.Errorf("Failed to list %s provisioning profiles\n%v", profileType, err)
 	}
-	return nil
-}
 
-func FindProfileWithName(client *appstoreconnect.Client, profileName string) (*appstoreconnect.Profile, error) {
-	// find profiles with name
-	profiles, err := FindProfile(client, profileName)
-	if err != nil {
-		return nil, err
+	for _, p := range profiles {
+		if p.Attributes.UUID == uuid {
+			p := p
+			return &p, nil
+		}
 	}
 
-	var profile *appstoreconnect.Profile = nil
+	log.Warnf("Provisioning profile with UUID %s not found, looking for %s provisioning profile named %s for bundle ID %s", uuid, profileType, name, bundleIdentifier)
+
+	var matches, wildcardMatches []appstoreconnect.Profile
 	for _, p := range profiles {
-		if p.Attributes.Name != profileName {
+		if p.Attributes.Name != name {
 			continue
 		}
 
-		profile = &p
-		break
-	}
-	if profile == nil {
-		return nil, fmt.Errorf("Failed to locate Provisioning Profile on Apple Developer Portal with name: %s", profileName)
+		bundleIDResponse, err := client.Provisioning.BundleID(p.Relationships.BundleID.Links.Related)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to get bundle ID of provisioning profile %s (%s)\n%v", p.Attributes.Name, p.Attributes.UUID, err)
+		}
+
+		identifier := bundleIDResponse.Data.Attributes.Identifier
+		if identifier == bundleIdentifier {
+			matches = append(matches, p)
+		} else if isWildcardBundleIDMatching(identifier, bundleIdentifier) {
+			wildcardMatches = append(wildcardMatches, p)
+		}
 	}
 
-	return profile, nil
-}
+	// An explicit bundle ID takes precedence over the wildcard ones
+	if len(matches) == 0 {
+		matches = wildcardMatches
+	}
 
-// ListProfiles ...
-func FindProfile(client *appstoreconnect.Client, name string) ([]appstoreconnect.Profile, error) {
-	opt := &appstoreconnect.ListProfilesOptions{
-		PagingOptions: appstoreconnect.PagingOptions{
-			Limit: 100,
-		},
-		FilterName: name,
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("Failed to locate Provisioning Profile on Apple Developer Portal with UUID: %s, or with name: %s, bundle ID: %s and type: %s", uuid, name, bundleIdentifier, profileType)
+	case 1:
+		return &matches[0], nil
 	}
 
-	r, err := client.Provisioning.ListProfiles(opt)
-	if err != nil {
-		return nil, err
+	var uuids []string
+	for _, p := range matches {
+		uuids = append(uuids, p.Attributes.UUID)
 	}
+	return nil, fmt.Errorf("Ambiguous provisioning profile: %d %s provisioning profiles named %s for bundle ID %s found on Apple Developer Portal (%s)", len(matches), profileType, name, bundleIdentifier, strings.Join(uuids, ", "))
+}
 
-	if len(r.Data) == 0 {
-		return nil, nil
+// isWildcardBundleIDMatching reports whether the wildcard bundle ID (like io.bitrise.* or *) matches the bundle ID
+func isWildcardBundleIDMatching(wildcardBundleID, bundleIdentifier string) bool {
+	if !strings.HasSuffix(wildcardBundleID, "*") {
+		return false
 	}
+	return strings.HasPrefix(bundleIdentifier, strings.TrimSuffix(wildcardBundleID, "*"))
+}
 
-	return r.Data, nil
+// FindProfile returns every profile whose name contains the given name
+func FindProfile(client *appstoreconnect.Client, name string) ([]appstoreconnect.Profile, error) {
+	var profiles []appstoreconnect.Profile
+	var nextPageURL string
+
+	for {
+		response, err := client.Provisioning.ListProfiles(&appstoreconnect.ListProfilesOptions{
+			PagingOptions: appstoreconnect.PagingOptions{
+				Limit: 100,
+				Next:  nextPageURL,
+			},
+			FilterName: name,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		profiles = append(profiles, response.Data...)
+
+		nextPageURL = response.Links.Next
+		if nextPageURL == "" {
+			return profiles, nil
+		}
+	}
 }
 
-//
-func XCarxhiveExportOption(exportBundleID string, distributionType string, certificate string, bundleIDsWithProfiles map[string]string, teamID string) string {
-	provisioningProfiles := ""
-	for bundleID, profileName := range bundleIDsWithProfiles {
-		profileIdentifier := fmt.Sprintf(`
-			<key>%s</key>
-			<string>%s</string>`,
-			bundleID,
-			profileName)
-
-		if len(provisioningProfiles) == 0 {
-			provisioningProfiles = profileIdentifier
-		} else {
-			profileIdentifier = strings.Join([]string{
-				provisioningProfiles,
-				profileIdentifier,
-			}, "\n")
-		}
-	}
-
-	return fmt.Sprintf(`
-<?xml version="1.0" encoding="UTF-8"?>
-<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
-<plist version="1.0">
-	<dict>
-		<key>distributionBundleIdentifier</key>
-		<string>%s</string>
-		<key>method</key>
-		<string>%s</string>
-		<key>provisioningProfiles</key>
-		<dict>%s
-		</dict>
-		<key>signingCertificate</key>
-		<string>%s</string>
-		<key>teamID</key>
-		<string>%s</string>
-	</dict>
-</plist>
-`,
-		exportBundleID,
-		distributionType,
-		provisioningProfiles,
-		certificate,
-		teamID,
-	)
+// ListProfilesWithType returns every profile of the given type
+func ListProfilesWithType(client *appstoreconnect.Client, profileType appstoreconnect.ProfileType) ([]appstoreconnect.Profile, error) {
+	var profiles []appstoreconnect.Profile
+	var nextPageURL string
+
+	for {
+		response, err := client.Provisioning.ListProfiles(&appstoreconnect.ListProfilesOptions{
+			PagingOptions: appstoreconnect.PagingOptions{
+				Limit: 100,
+				Next:  nextPageURL,
+			},
+			FilterProfileType: profileType,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		profiles = append(profiles, response.Data...)
+
+		nextPageURL = response.Links.Next
+		if nextPageURL == "" {
+			return profiles, nil
+		}
+	}
 }
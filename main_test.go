@@ -0,0 +1,69 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/appstoreconnect"
+)
+
+func TestFindProfileWithUUID(t *testing.T) {
+	portal := newFakePortal(
+		fakeProfile{ID: "explicit", Name: "Sample", UUID: "explicit-uuid", ProfileType: appstoreconnect.IOSAppDevelopment, BundleIdentifier: "io.bitrise.sample"},
+		fakeProfile{ID: "wildcard", Name: "Wildcard", UUID: "wildcard-uuid", ProfileType: appstoreconnect.IOSAppDevelopment, BundleIdentifier: "io.bitrise.*"},
+		fakeProfile{ID: "adhoc", Name: "Wildcard", UUID: "adhoc-uuid", ProfileType: appstoreconnect.IOSAppAdHoc, BundleIdentifier: "io.bitrise.*"},
+		fakeProfile{ID: "duplicate-1", Name: "Duplicate", UUID: "duplicate-uuid-1", ProfileType: appstoreconnect.IOSAppDevelopment, BundleIdentifier: "io.bitrise.sample"},
+		fakeProfile{ID: "duplicate-2", Name: "Duplicate", UUID: "duplicate-uuid-2", ProfileType: appstoreconnect.IOSAppDevelopment, BundleIdentifier: "io.bitrise.sample"},
+	)
+	regeneratedProfiles := map[string]appstoreconnect.Profile{
+		"regenerated-uuid": portal.profile(t, "wildcard"),
+	}
+
+	tests := []struct {
+		name             string
+		uuid             string
+		profileName      string
+		bundleIdentifier string
+		wantID           string
+		wantErr          bool
+	}{
+		{name: "by UUID", uuid: "explicit-uuid", profileName: "Sample", bundleIdentifier: "io.bitrise.sample", wantID: "explicit"},
+		{name: "regenerated in this run", uuid: "regenerated-uuid", profileName: "Wildcard", bundleIdentifier: "io.bitrise.sample.share", wantID: "wildcard"},
+		{name: "by name and bundle ID", uuid: "missing-uuid", profileName: "Sample", bundleIdentifier: "io.bitrise.sample", wantID: "explicit"},
+		{name: "by name and wildcard bundle ID", uuid: "missing-uuid", profileName: "Wildcard", bundleIdentifier: "io.bitrise.sample.share", wantID: "wildcard"},
+		{name: "wildcard bundle ID not matching", uuid: "missing-uuid", profileName: "Wildcard", bundleIdentifier: "io.other.sample", wantErr: true},
+		{name: "ambiguous", uuid: "missing-uuid", profileName: "Duplicate", bundleIdentifier: "io.bitrise.sample", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			profile, err := FindProfileWithUUID(portal.client(), regeneratedProfiles, tt.uuid, tt.profileName, tt.bundleIdentifier, appstoreconnect.IOSAppDevelopment)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("FindProfileWithUUID() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if profile.ID != tt.wantID {
+				t.Errorf("FindProfileWithUUID() = %s, want %s", profile.ID, tt.wantID)
+			}
+		})
+	}
+}
+
+func TestIsWildcardBundleIDMatching(t *testing.T) {
+	tests := []struct {
+		wildcardBundleID string
+		bundleIdentifier string
+		want             bool
+	}{
+		{wildcardBundleID: "*", bundleIdentifier: "io.bitrise.sample", want: true},
+		{wildcardBundleID: "io.bitrise.*", bundleIdentifier: "io.bitrise.sample", want: true},
+		{wildcardBundleID: "io.bitrise.*", bundleIdentifier: "io.bitrise.sample.share", want: true},
+		{wildcardBundleID: "io.bitrise.*", bundleIdentifier: "io.other.sample", want: false},
+		{wildcardBundleID: "io.bitrise.sample", bundleIdentifier: "io.bitrise.sample", want: false},
+	}
+	for _, tt := range tests {
+		if got := isWildcardBundleIDMatching(tt.wildcardBundleID, tt.bundleIdentifier); got != tt.want {
+			t.Errorf("isWildcardBundleIDMatching(%s, %s) = %v, want %v", tt.wildcardBundleID, tt.bundleIdentifier, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/birmacher/steps-register-ios-device/profile"
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/appstoreconnect"
+	"github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/autoprovision"
+)
+
+// manualProfileName returns the name of the manual profile managed by the step for the bundle ID, like "Bitrise AdHoc com.example.app"
+func manualProfileName(profileType appstoreconnect.ProfileType, bundleID string) string {
+	kind := "AdHoc"
+	if profileType.ReadableString() == "development" {
+		kind = "Development"
+	}
+	return fmt.Sprintf("Bitrise %s %s", kind, bundleID)
+}
+
+// adHocProfileType returns the ad-hoc profile type matching the platform of the profile
+func adHocProfileType(embeddedProfile profile.Profile) appstoreconnect.ProfileType {
+	if embeddedProfile.HasPlatform("tvOS") {
+		return appstoreconnect.TvOSAppAdHoc
+	}
+	return appstoreconnect.IOSAppAdHoc
+}
+
+// deviceProfileType returns the type of a development or ad-hoc profile, based on its platform and entitlements
+func deviceProfileType(embeddedProfile profile.Profile) appstoreconnect.ProfileType {
+	switch {
+	case embeddedProfile.HasPlatform("OSX"):
+		return appstoreconnect.MacAppDevelopment
+	case embeddedProfile.HasPlatform("tvOS") && embeddedProfile.IsDevelopment():
+		return appstoreconnect.TvOSAppDevelopment
+	case embeddedProfile.HasPlatform("tvOS"):
+		return appstoreconnect.TvOSAppAdHoc
+	case embeddedProfile.IsDevelopment():
+		return appstoreconnect.IOSAppDevelopment
+	}
+	return appstoreconnect.IOSAppAdHoc
+}
+
+// validCertificateIDs returns the App Store Connect IDs of the profile's certificates which are not expired
+func validCertificateIDs(client *appstoreconnect.Client, embeddedProfile profile.Profile) ([]string, error) {
+	certificates, err := embeddedProfile.Certificates()
+	if err != nil {
+		return nil, err
+	}
+
+	var certificateIDs []string
+	for _, certificate := range certificates {
+		if time.Now().After(certificate.NotAfter) {
+			log.Warnf("Certificate %s expired at %s, skipping", certificate.Subject.CommonName, certificate.NotAfter)
+			continue
+		}
+
+		ascCertificate, err := client.Provisioning.FetchCertificate(certificate.SerialNumber.Text(16))
+		if err != nil {
+			log.Warnf("Certificate %s not found on App Store Connect, skipping:\n%v", certificate.Subject.CommonName, err)
+			continue
+		}
+		certificateIDs = append(certificateIDs, ascCertificate.ID)
+	}
+
+	if len(certificateIDs) == 0 {
+		return nil, fmt.Errorf("No valid certificate of provisioning profile %s found on App Store Connect", embeddedProfile.Name)
+	}
+	return certificateIDs, nil
+}
+
+// EnsureManualProfile finds the manual profile of the given type managed by the step for the bundle ID,
+// or creates it with the bundle ID and valid certificates of the embedded profile.
+// It replaces the App Store, Enterprise and Xcode managed profiles, which can not be recreated with the devices.
+// The returned bool reports whether the profile was created.
+// In a dry run (dryRunPlan is set) a missing profile is not created, it is added to the plan and nil is returned.
+func EnsureManualProfile(client *appstoreconnect.Client, membership ProfileMembership, profileType appstoreconnect.ProfileType, bundleIdentifier string, embeddedProfile profile.Profile, dryRunPlan *Plan) (*appstoreconnect.Profile, bool, error) {
+	name := manualProfileName(profileType, bundleIdentifier)
+
+	profiles, err := FindProfile(client, name)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var invalidProfile *appstoreconnect.Profile
+	for _, p := range profiles {
+		if p.Attributes.Name != name || p.Attributes.ProfileType != profileType {
+			continue
+		}
+		p := p
+		if p.Attributes.ProfileState == appstoreconnect.Active {
+			log.Printf("Using manual provisioning profile: %s (%s)", p.Attributes.Name, p.Attributes.UUID)
+			return &p, false, nil
+		}
+		invalidProfile = &p
+	}
+
+	bundleID, err := autoprovision.FindBundleID(client, bundleIdentifier)
+	if err != nil {
+		return nil, false, fmt.Errorf("Failed to find bundle ID %s\n%v", bundleIdentifier, err)
+	}
+	if bundleID == nil {
+		return nil, false, fmt.Errorf("Bundle ID %s not found on App Store Connect", bundleIdentifier)
+	}
+
+	certificateIDs, err := validCertificateIDs(client, embeddedProfile)
+	if err != nil {
+		return nil, false, err
+	}
+
+	deviceIDs, err := membership.DeviceIDs(client, &appstoreconnect.Profile{Attributes: appstoreconnect.ProfileAttributes{Name: name, ProfileType: profileType}}, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(deviceIDs) == 0 && dryRunPlan == nil {
+		return nil, false, fmt.Errorf("No registered device to include in manual provisioning profile %s", name)
+	}
+
+	if dryRunPlan != nil {
+		log.Printf("Dry run: manual provisioning profile %s would be created with %d device(s)", name, len(deviceIDs))
+		profilePlan := ProfilePlan{
+			Action:         PlanCreate,
+			Name:           name,
+			ProfileType:    profileType,
+			BundleID:       bundleIdentifier,
+			DeviceIDs:      deviceIDs,
+			CertificateIDs: certificateIDs,
+		}
+		if invalidProfile != nil {
+			profilePlan.UUID = invalidProfile.Attributes.UUID
+		}
+		dryRunPlan.Profiles = append(dryRunPlan.Profiles, profilePlan)
+		return nil, false, nil
+	}
+
+	if invalidProfile != nil {
+		log.Printf("Deleting invalid manual provisioning profile: %s (%s)", invalidProfile.Attributes.Name, invalidProfile.Attributes.UUID)
+		if err := DeleteProfile(client, invalidProfile.ID); err != nil {
+			return nil, false, err
+		}
+	}
+
+	log.Printf("Creating manual provisioning profile on Apple Developer Portal: %s", name)
+	adHocProfile, err := autoprovision.CreateProfile(client, name, profileType, *bundleID, certificateIDs, deviceIDs)
+	if err != nil {
+		return nil, false, err
+	}
+	log.Donef("Provisioning profile %s (%s) successfully created on Apple Deveper Portal", adHocProfile.Attributes.Name, adHocProfile.Attributes.UUID)
+
+	return adHocProfile, true, nil
+}
+
+// manualProfileResult is the manual profile used for a bundle instead of its embedded profile
+type manualProfileResult struct {
+	// Profile is nil in a dry run, if the profile would be created
+	Profile *appstoreconnect.Profile
+	Name    string
+	// Created reports whether the profile was created in this run, including the devices
+	Created bool
+}
+
+// Done reports whether nothing else has to be done with the bundle:
+// a newly created profile already includes the devices, a dry run only plans the missing profile.
+func (r manualProfileResult) Done() bool {
+	return r.Profile == nil || r.Created
+}
+
+// useManualProfile returns the manual profile managed by the step for the bundle, used instead of its embedded profile
+func useManualProfile(client *appstoreconnect.Client, membership ProfileMembership, profileType appstoreconnect.ProfileType, bundleIdentifier string, embeddedProfile profile.Profile, dryRunPlan *Plan) (manualProfileResult, error) {
+	manualProfile, created, err := EnsureManualProfile(client, membership, profileType, bundleIdentifier, embeddedProfile, dryRunPlan)
+	if err != nil {
+		return manualProfileResult{}, err
+	}
+
+	return manualProfileResult{
+		Profile: manualProfile,
+		Name:    manualProfileName(profileType, bundleIdentifier),
+		Created: created,
+	}, nil
+}
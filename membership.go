@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/birmacher/steps-register-ios-device/device"
+	"github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/appstoreconnect"
+)
+
+// Profile device membership strategies
+const (
+	// MembershipAll includes every enabled device of the team compatible with the profile
+	MembershipAll = "all"
+	// MembershipExistingPlusNew keeps the devices of the profile and adds the devices of this run
+	MembershipExistingPlusNew = "existing-plus-new"
+	// MembershipExplicit includes the explicitly listed devices only
+	MembershipExplicit = "explicit"
+)
+
+// isDeviceCompatibleWithProfile reports whether the device class can be included in the profile type
+func isDeviceCompatibleWithProfile(profileType appstoreconnect.ProfileType, ascDevice appstoreconnect.Device) bool {
+	class := ascDevice.Attributes.DeviceClass
+	switch {
+	case strings.HasPrefix(string(profileType), "TVOS"):
+		return class == appstoreconnect.AppleTV
+	case strings.HasPrefix(string(profileType), "IOS"):
+		return class == appstoreconnect.Iphone || class == appstoreconnect.Ipad || class == appstoreconnect.Ipod || class == appstoreconnect.AppleWatch
+	case isMacProfileType(profileType):
+		return class == appstoreconnect.Mac
+	}
+	return true
+}
+
+func isMacProfileType(profileType appstoreconnect.ProfileType) bool {
+	return strings.HasPrefix(string(profileType), "MAC")
+}
+
+// profileDevicePlatform returns the platform of the devices the profile type can include
+func profileDevicePlatform(profileType appstoreconnect.ProfileType) appstoreconnect.DevicePlatform {
+	if isMacProfileType(profileType) {
+		return appstoreconnect.MacOSDevice
+	}
+	return appstoreconnect.IOSDevice
+}
+
+// devicesForProfileType returns the devices whose class can be included in the profile type.
+// The class of a registered device comes from the index, the class of a device not registered yet is inferred.
+func devicesForProfileType(index *device.DeviceIndex, devices []device.Device, profileType appstoreconnect.ProfileType) []device.Device {
+	var filtered []device.Device
+	for _, d := range devices {
+		if ascDevice := lookupEnabledDevice(index, d.UDID); ascDevice != nil {
+			if isDeviceCompatibleWithProfile(profileType, *ascDevice) {
+				filtered = append(filtered, d)
+			}
+			continue
+		}
+
+		for _, class := range d.Classes() {
+			if isDeviceCompatibleWithProfile(profileType, appstoreconnect.Device{Attributes: appstoreconnect.DeviceAttributes{DeviceClass: class}}) {
+				filtered = append(filtered, d)
+				break
+			}
+		}
+	}
+	return filtered
+}
+
+// lookupEnabledDevice returns the enabled registered device with the given UDID
+func lookupEnabledDevice(index *device.DeviceIndex, udid string) *appstoreconnect.Device {
+	for _, ascDevice := range index.Lookup(udid) {
+		if ascDevice.Attributes.Status == appstoreconnect.Enabled {
+			return &ascDevice
+		}
+	}
+	return nil
+}
+
+// ProfileMembership resolves the devices of a regenerated profile
+type ProfileMembership struct {
+	Strategy string
+	// Index holds the registered devices of the team
+	Index *device.DeviceIndex
+	// Devices are the devices of this run, added by the existing-plus-new strategy
+	Devices []device.Device
+	// ExplicitUDIDs are the devices of the explicit strategy
+	ExplicitUDIDs []string
+}
+
+// DeviceIDs returns the IDs of the devices the regenerated profile should include
+func (m ProfileMembership) DeviceIDs(client *appstoreconnect.Client, profile *appstoreconnect.Profile, devicesInProfile []appstoreconnect.Device) ([]string, error) {
+	switch m.Strategy {
+	case MembershipAll:
+		return GetAllRegisteredDevices(client, profile)
+	case MembershipExistingPlusNew:
+		var deviceIDs []string
+		added := map[string]bool{}
+		for _, deviceInProfile := range devicesInProfile {
+			if !added[deviceInProfile.ID] {
+				added[deviceInProfile.ID] = true
+				deviceIDs = append(deviceIDs, deviceInProfile.ID)
+			}
+		}
+
+		for _, d := range m.Devices {
+			// Devices registered in a dry run have no ID yet
+			ascDevice := lookupEnabledDevice(m.Index, d.UDID)
+			if ascDevice == nil || added[ascDevice.ID] || !isDeviceCompatibleWithProfile(profile.Attributes.ProfileType, *ascDevice) {
+				continue
+			}
+			added[ascDevice.ID] = true
+			deviceIDs = append(deviceIDs, ascDevice.ID)
+		}
+		return deviceIDs, nil
+	case MembershipExplicit:
+		var deviceIDs []string
+		added := map[string]bool{}
+		for _, udid := range m.ExplicitUDIDs {
+			ascDevice := lookupEnabledDevice(m.Index, udid)
+			if ascDevice == nil {
+				return nil, fmt.Errorf("Device %s listed in profile_device_udids is not an enabled device on App Store Connect", udid)
+			}
+			// The list may hold the devices of every platform of the archive
+			if !isDeviceCompatibleWithProfile(profile.Attributes.ProfileType, *ascDevice) {
+				continue
+			}
+			if !added[ascDevice.ID] {
+				added[ascDevice.ID] = true
+				deviceIDs = append(deviceIDs, ascDevice.ID)
+			}
+		}
+		return deviceIDs, nil
+	}
+
+	return nil, fmt.Errorf("Unknown profile device membership strategy: %s", m.Strategy)
+}
+
+// IsExplicitMembershipChanged reports whether the devices of the profile differ from the given device IDs
+func IsExplicitMembershipChanged(devicesInProfile []appstoreconnect.Device, deviceIDs []string) bool {
+	if len(devicesInProfile) != len(deviceIDs) {
+		return true
+	}
+
+	ids := map[string]bool{}
+	for _, id := range deviceIDs {
+		ids[id] = true
+	}
+	for _, deviceInProfile := range devicesInProfile {
+		if !ids[deviceInProfile.ID] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/birmacher/steps-register-ios-device/device"
+	"github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/appstoreconnect"
+)
+
+func TestDevicesForProfileType(t *testing.T) {
+	registered := func(id, udid string, platform appstoreconnect.BundleIDPlatform, class appstoreconnect.DeviceClass) appstoreconnect.Device {
+		return appstoreconnect.Device{ID: id, Attributes: appstoreconnect.DeviceAttributes{
+			UDID:        udid,
+			Platform:    platform,
+			DeviceClass: class,
+			Status:      appstoreconnect.Enabled,
+		}}
+	}
+	index := device.NewDeviceIndex([]appstoreconnect.Device{
+		registered("iphone", "00008030-001A2B3C4D5E6F70", appstoreconnect.IOS, appstoreconnect.Iphone),
+		registered("appletv", "0123456789abcdef0123456789abcdef01234567", appstoreconnect.IOS, appstoreconnect.AppleTV),
+		registered("mac", "00008103-000A1B2C3D4E5F60", appstoreconnect.MacOS, appstoreconnect.Mac),
+	})
+
+	devices := []device.Device{
+		{Name: "iPhone", UDID: "00008030-001A2B3C4D5E6F70", Platform: "ios"},
+		{Name: "Apple TV", UDID: "0123456789abcdef0123456789abcdef01234567", Platform: "ios"},
+		{Name: "Mac", UDID: "00008103-000A1B2C3D4E5F60", Platform: "osx"},
+		// Not registered yet, the class is inferred from the model identifier
+		{Name: "New Apple TV", UDID: "00008110-000A1B2C3D4E5F61", Platform: "ios", Model: "AppleTV11,1"},
+	}
+
+	tests := []struct {
+		profileType appstoreconnect.ProfileType
+		want        []string
+	}{
+		{profileType: appstoreconnect.IOSAppDevelopment, want: []string{"iPhone"}},
+		{profileType: appstoreconnect.TvOSAppAdHoc, want: []string{"Apple TV", "New Apple TV"}},
+		{profileType: appstoreconnect.MacAppDevelopment, want: []string{"Mac"}},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.profileType), func(t *testing.T) {
+			var got []string
+			for _, d := range devicesForProfileType(index, devices, tt.profileType) {
+				got = append(got, d.Name)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("devicesForProfileType() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("devicesForProfileType() = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/birmacher/steps-register-ios-device/xcarchive"
+	"github.com/bitrise-io/go-steputils/tools"
+	"github.com/bitrise-io/go-xcode/exportoptions"
+	"howett.net/plist"
+)
+
+const (
+	otaManifestFileName    = "manifest.plist"
+	otaInstallPageFileName = "install.html"
+)
+
+// OTAInstall describes the over-the-air installation of the app exported from the archive
+type OTAInstall struct {
+	// BaseURL is the URL the exported .ipa and the manifest are downloaded from
+	BaseURL     string
+	IPAName     string
+	DisplayName string
+	BundleID    string
+	Version     string
+}
+
+// NewOTAInstall ...
+func NewOTAInstall(config Config, archive xcarchive.Archive) OTAInstall {
+	application := archive.Application
+
+	displayName := config.OTADisplayName
+	if displayName == "" {
+		displayName = application.DisplayName
+	}
+
+	return OTAInstall{
+		BaseURL:     strings.TrimSuffix(config.OTABaseURL, "/"),
+		IPAName:     strings.TrimSuffix(filepath.Base(application.Path), filepath.Ext(application.Path)) + ".ipa",
+		DisplayName: displayName,
+		BundleID:    application.BundleID,
+		Version:     application.Version,
+	}
+}
+
+// AppURL returns the download URL of the exported .ipa
+func (o OTAInstall) AppURL() string {
+	return o.BaseURL + "/" + url.PathEscape(o.IPAName)
+}
+
+// ManifestURL returns the download URL of the manifest
+func (o OTAInstall) ManifestURL() string {
+	return o.BaseURL + "/" + otaManifestFileName
+}
+
+// Manifest returns the manifest export option built from the export_manifest_* inputs, the app URL defaults to the .ipa under the base URL
+func (o OTAInstall) Manifest(manifest exportoptions.Manifest) exportoptions.Manifest {
+	if manifest.AppURL == "" {
+		manifest.AppURL = o.AppURL()
+	}
+	return manifest
+}
+
+type otaAsset struct {
+	Kind string `plist:"kind"`
+	URL  string `plist:"url"`
+}
+
+type otaMetadata struct {
+	BundleIdentifier string `plist:"bundle-identifier"`
+	BundleVersion    string `plist:"bundle-version"`
+	Kind             string `plist:"kind"`
+	Title            string `plist:"title"`
+}
+
+type otaItem struct {
+	Assets   []otaAsset  `plist:"assets"`
+	Metadata otaMetadata `plist:"metadata"`
+}
+
+type otaManifest struct {
+	Items []otaItem `plist:"items"`
+}
+
+// ManifestPlist returns the content of the manifest.plist referred by the itms-services:// link
+func (o OTAInstall) ManifestPlist(manifest exportoptions.Manifest) ([]byte, error) {
+	assets := []otaAsset{{Kind: "software-package", URL: manifest.AppURL}}
+	if manifest.DisplayImageURL != "" {
+		assets = append(assets, otaAsset{Kind: "display-image", URL: manifest.DisplayImageURL})
+	}
+	if manifest.FullSizeImageURL != "" {
+		assets = append(assets, otaAsset{Kind: "full-size-image", URL: manifest.FullSizeImageURL})
+	}
+
+	content, err := plist.MarshalIndent(otaManifest{
+		Items: []otaItem{{
+			Assets: assets,
+			Metadata: otaMetadata{
+				BundleIdentifier: o.BundleID,
+				BundleVersion:    o.Version,
+				Kind:             "software",
+				Title:            o.DisplayName,
+			},
+		}},
+	}, plist.XMLFormat, "\t")
+	if err != nil {
+		return nil, fmt.Errorf("Failed to serialize OTA manifest\n%v", err)
+	}
+	return content, nil
+}
+
+var otaInstallPageTemplate = template.Must(template.New("install").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Install {{.DisplayName}}</title>
+</head>
+<body>
+{{if .DisplayImageURL}}<img src="{{.DisplayImageURL}}" alt="{{.DisplayName}}" width="57" height="57">
+{{end}}<h1>{{.DisplayName}}</h1>
+<p>Version {{.Version}} ({{.BundleID}})</p>
+<p><a href="{{.InstallURL}}">Install</a></p>
+</body>
+</html>
+`))
+
+// InstallPage returns the HTML page with the itms-services:// install link
+func (o OTAInstall) InstallPage(manifest exportoptions.Manifest) ([]byte, error) {
+	var page bytes.Buffer
+	if err := otaInstallPageTemplate.Execute(&page, struct {
+		DisplayName     string
+		DisplayImageURL string
+		Version         string
+		BundleID        string
+		InstallURL      template.URL
+	}{
+		DisplayName:     o.DisplayName,
+		DisplayImageURL: manifest.DisplayImageURL,
+		Version:         o.Version,
+		BundleID:        o.BundleID,
+		InstallURL:      template.URL("itms-services://?action=download-manifest&url=" + url.QueryEscape(o.ManifestURL())),
+	}); err != nil {
+		return nil, fmt.Errorf("Failed to generate OTA install page\n%v", err)
+	}
+	return page.Bytes(), nil
+}
+
+// WriteOTAInstall writes the manifest.plist and the install page to the directory and exports their paths
+func WriteOTAInstall(ota OTAInstall, manifest exportoptions.Manifest, dir string) error {
+	if dir == "" {
+		return fmt.Errorf("deploy_dir is required to write the OTA install manifest and page")
+	}
+
+	manifestContent, err := ota.ManifestPlist(manifest)
+	if err != nil {
+		return err
+	}
+	pageContent, err := ota.InstallPage(manifest)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("Failed to create directory: %s\n%v", dir, err)
+	}
+
+	for _, file := range []struct {
+		name    string
+		content []byte
+		env     string
+	}{
+		{name: otaManifestFileName, content: manifestContent, env: "BITRISE_OTA_MANIFEST_PATH"},
+		{name: otaInstallPageFileName, content: pageContent, env: "BITRISE_OTA_INSTALL_PAGE_PATH"},
+	} {
+		pth := filepath.Join(dir, file.name)
+		if err := ioutil.WriteFile(pth, file.content, 0644); err != nil {
+			return fmt.Errorf("Failed to write %s\n%v", pth, err)
+		}
+		if err := tools.ExportEnvironmentWithEnvman(file.env, pth); err != nil {
+			return fmt.Errorf("Failed to export %s\n%v", file.env, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/birmacher/steps-register-ios-device/device"
+	"github.com/bitrise-io/go-steputils/tools"
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/appstoreconnect"
+)
+
+// Profile plan actions
+const (
+	PlanRecreate = "recreate"
+	PlanCreate   = "create"
+)
+
+// ProfilePlan describes a profile which would be deleted and recreated, or created
+type ProfilePlan struct {
+	Action string `json:"action"`
+	Name   string `json:"name"`
+	// UUID is the UUID of the current profile, empty if a missing profile would be created
+	UUID        string                      `json:"uuid"`
+	ProfileType appstoreconnect.ProfileType `json:"profile_type"`
+	BundleID    string                      `json:"bundle_id"`
+	// DeviceIDs are the IDs of the registered devices the recreated profile would contain
+	DeviceIDs []string `json:"device_ids"`
+	// AddedDeviceUDIDs are the UDIDs of the devices missing from the current profile
+	AddedDeviceUDIDs []string `json:"added_device_udids"`
+	CertificateIDs   []string `json:"certificate_ids"`
+}
+
+// Plan is the list of changes a dry run would make on the Developer Portal
+type Plan struct {
+	Devices  []device.Result `json:"devices"`
+	Profiles []ProfilePlan   `json:"profiles"`
+}
+
+// NewProfilePlan ...
+func NewProfilePlan(regeneration ProfileRegeneration, addedDevices []device.Device) ProfilePlan {
+	plan := ProfilePlan{
+		Action:         PlanRecreate,
+		Name:           regeneration.Profile.Attributes.Name,
+		UUID:           regeneration.Profile.Attributes.UUID,
+		ProfileType:    regeneration.Profile.Attributes.ProfileType,
+		BundleID:       regeneration.BundleID.Attributes.Identifier,
+		DeviceIDs:      regeneration.DeviceIDs,
+		CertificateIDs: regeneration.CertificateIDs,
+	}
+	for _, d := range addedDevices {
+		plan.AddedDeviceUDIDs = append(plan.AddedDeviceUDIDs, d.UDID)
+	}
+	return plan
+}
+
+// Print logs the plan in a human readable format
+func (p Plan) Print() {
+	log.Printf("")
+	log.Infof("Dry run plan")
+
+	log.Printf("Devices:")
+	for _, result := range p.Devices {
+		switch result.Status {
+		case device.StatusRegistered, device.StatusReEnabled:
+			log.Printf("- %s (%s) would be %s", result.Device.Name, result.Device.UDID, result.Status)
+		}
+	}
+
+	log.Printf("Provisioning profiles to recreate or create:")
+	for _, profile := range p.Profiles {
+		log.Printf("- %s (%s) would be %sd, %s, bundle ID: %s", profile.Name, profile.UUID, profile.Action, profile.ProfileType, profile.BundleID)
+		log.Printf("  registered devices: %d, added devices: %v", len(profile.DeviceIDs), profile.AddedDeviceUDIDs)
+		log.Printf("  certificates: %v", profile.CertificateIDs)
+	}
+}
+
+func exportPlan(plan Plan) error {
+	planJSON, err := json.Marshal(plan)
+	if err != nil {
+		return fmt.Errorf("Failed to serialize dry run plan\n%v", err)
+	}
+
+	if err := tools.ExportEnvironmentWithEnvman("BITRISE_DRY_RUN_PLAN", string(planJSON)); err != nil {
+		return fmt.Errorf("Failed to export BITRISE_DRY_RUN_PLAN\n%v", err)
+	}
+	return nil
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/appstoreconnect"
+)
+
+const fakePortalURL = "https://api.appstoreconnect.apple.com/v1/"
+
+// fakeProfile is a profile stored by the fake Developer Portal
+type fakeProfile struct {
+	ID          string
+	Name        string
+	UUID        string
+	ProfileType appstoreconnect.ProfileType
+	BundleIDID  string
+	// BundleIdentifier is the identifier of the bundle ID, like io.bitrise.sample or io.bitrise.*
+	BundleIdentifier string
+	CertificateIDs   []string
+	DeviceIDs        []string
+}
+
+// fakePortal is an in-memory Developer Portal serving the profile endpoints of the App Store Connect API
+type fakePortal struct {
+	profiles map[string]*fakeProfile
+	// createFailures is the number of profile creations to reject
+	createFailures int
+	// deleteFailure rejects the profile deletions without deleting the profile
+	deleteFailure bool
+	// dropDeleteResponse deletes the profile, but drops the connection before the response
+	dropDeleteResponse bool
+	// created are the profiles created through the API, in order
+	created  []fakeProfile
+	requests []string
+	nextID   int
+}
+
+func newFakePortal(profiles ...fakeProfile) *fakePortal {
+	portal := &fakePortal{profiles: map[string]*fakeProfile{}}
+	for _, profile := range profiles {
+		profile := profile
+		portal.profiles[profile.ID] = &profile
+	}
+	return portal
+}
+
+func (p *fakePortal) client() *appstoreconnect.Client {
+	// The fake is not an *http.Client, so the requests are not signed
+	return appstoreconnect.NewClient(p, "", "", nil)
+}
+
+func (p *fakePortal) profileJSON(profile fakeProfile) map[string]interface{} {
+	link := func(relationship string) map[string]interface{} {
+		return map[string]interface{}{"links": map[string]string{"related": fakePortalURL + "profiles/" + profile.ID + "/" + relationship}}
+	}
+
+	return map[string]interface{}{
+		"type": "profiles",
+		"id":   profile.ID,
+		"attributes": map[string]interface{}{
+			"name":           profile.Name,
+			"uuid":           profile.UUID,
+			"profileType":    profile.ProfileType,
+			"profileState":   appstoreconnect.Active,
+			"expirationDate": "2027-01-01T12:00:00.000+0000",
+		},
+		"relationships": map[string]interface{}{
+			"bundleId":     link("bundleId"),
+			"certificates": link("certificates"),
+			"devices":      link("devices"),
+		},
+	}
+}
+
+func response(req *http.Request, status int, body interface{}) (*http.Response, error) {
+	content, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Response{
+		StatusCode: status,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       ioutil.NopCloser(bytes.NewReader(content)),
+		Request:    req,
+	}, nil
+}
+
+func errorResponse(req *http.Request, status int, code string) (*http.Response, error) {
+	return response(req, status, map[string]interface{}{
+		"errors": []map[string]string{{"code": code, "title": code, "detail": req.Method + " " + req.URL.Path}},
+	})
+}
+
+// Do serves the request
+func (p *fakePortal) Do(req *http.Request) (*http.Response, error) {
+	pth := strings.TrimPrefix(strings.Replace(req.URL.Path, "//", "/", -1), "/v1/")
+	p.requests = append(p.requests, req.Method+" "+pth)
+	parts := strings.Split(pth, "/")
+
+	if parts[0] != "profiles" {
+		return errorResponse(req, http.StatusNotFound, "NOT_FOUND")
+	}
+
+	switch {
+	case req.Method == http.MethodGet && len(parts) == 1:
+		var profiles []map[string]interface{}
+		for _, profile := range p.sortedProfiles() {
+			if profileType := req.URL.Query().Get("filter[profileType]"); profileType == "" || profileType == string(profile.ProfileType) {
+				profiles = append(profiles, p.profileJSON(profile))
+			}
+		}
+		return response(req, http.StatusOK, map[string]interface{}{"data": profiles})
+	case req.Method == http.MethodPost && len(parts) == 1:
+		if p.createFailures > 0 {
+			p.createFailures--
+			return errorResponse(req, http.StatusConflict, "ENTITY_ERROR")
+		}
+
+		var request appstoreconnect.ProfileCreateRequest
+		if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+			return nil, err
+		}
+
+		p.nextID++
+		profile := fakeProfile{
+			ID:          fmt.Sprintf("created-%d", p.nextID),
+			Name:        request.Data.Attributes.Name,
+			UUID:        fmt.Sprintf("created-uuid-%d", p.nextID),
+			ProfileType: request.Data.Attributes.ProfileType,
+			BundleIDID:  request.Data.Relationships.BundleID.Data.ID,
+		}
+		for _, certificate := range request.Data.Relationships.Certificates.Data {
+			profile.CertificateIDs = append(profile.CertificateIDs, certificate.ID)
+		}
+		for _, device := range request.Data.Relationships.Devices.Data {
+			profile.DeviceIDs = append(profile.DeviceIDs, device.ID)
+		}
+		p.profiles[profile.ID] = &profile
+		p.created = append(p.created, profile)
+		return response(req, http.StatusCreated, map[string]interface{}{"data": p.profileJSON(profile)})
+	}
+
+	if len(parts) < 2 {
+		return errorResponse(req, http.StatusNotFound, "NOT_FOUND")
+	}
+	profile, ok := p.profiles[parts[1]]
+
+	switch {
+	case req.Method == http.MethodDelete && len(parts) == 2:
+		if p.deleteFailure {
+			return errorResponse(req, http.StatusInternalServerError, "INTERNAL_ERROR")
+		}
+		if !ok {
+			return errorResponse(req, http.StatusNotFound, "NOT_FOUND")
+		}
+		delete(p.profiles, parts[1])
+		if p.dropDeleteResponse {
+			return nil, errors.New("connection reset by peer")
+		}
+		return &http.Response{StatusCode: http.StatusNoContent, Body: ioutil.NopCloser(bytes.NewReader(nil)), Request: req}, nil
+	case !ok:
+		return errorResponse(req, http.StatusNotFound, "NOT_FOUND")
+	case req.Method == http.MethodGet && len(parts) == 2:
+		return response(req, http.StatusOK, map[string]interface{}{"data": p.profileJSON(*profile)})
+	case req.Method == http.MethodGet && parts[2] == "bundleId":
+		return response(req, http.StatusOK, appstoreconnect.BundleIDResponse{Data: appstoreconnect.BundleID{
+			Type:       "bundleIds",
+			ID:         profile.BundleIDID,
+			Attributes: appstoreconnect.BundleIDAttributes{Identifier: profile.BundleIdentifier},
+		}})
+	case req.Method == http.MethodGet && parts[2] == "devices":
+		var devices []appstoreconnect.Device
+		for _, id := range profile.DeviceIDs {
+			devices = append(devices, appstoreconnect.Device{Type: "devices", ID: id})
+		}
+		return response(req, http.StatusOK, appstoreconnect.DevicesResponse{Data: devices})
+	case req.Method == http.MethodGet && parts[2] == "certificates":
+		var certificates []appstoreconnect.Certificate
+		for _, id := range profile.CertificateIDs {
+			certificates = append(certificates, appstoreconnect.Certificate{Type: "certificates", ID: id})
+		}
+		return response(req, http.StatusOK, appstoreconnect.CertificatesResponse{Data: certificates})
+	}
+
+	return errorResponse(req, http.StatusNotFound, "NOT_FOUND")
+}
+
+// sortedProfiles returns the stored profiles ordered by ID
+func (p *fakePortal) sortedProfiles() []fakeProfile {
+	var ids []string
+	for id := range p.profiles {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var profiles []fakeProfile
+	for _, id := range ids {
+		profiles = append(profiles, *p.profiles[id])
+	}
+	return profiles
+}
+
+// profile returns the stored profile as the App Store Connect API serves it
+func (p *fakePortal) profile(t *testing.T, id string) appstoreconnect.Profile {
+	content, err := json.Marshal(p.profileJSON(*p.profiles[id]))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var profile appstoreconnect.Profile
+	if err := json.Unmarshal(content, &profile); err != nil {
+		t.Fatal(err)
+	}
+	return profile
+}
@@ -0,0 +1,119 @@
+package profile
+
+import (
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/bitrise-io/go-xcode/plistutil"
+	"github.com/bitrise-io/go-xcode/profileutil"
+	"github.com/fullsailor/pkcs7"
+	"howett.net/plist"
+)
+
+// Profile is the decoded content of a provisioning profile file
+type Profile struct {
+	Name           string
+	UUID           string
+	TeamID         string
+	TeamName       string
+	Platforms      []string
+	Entitlements   plistutil.PlistData
+	CreationDate   time.Time
+	ExpirationDate time.Time
+	// ProvisionedDevices is nil for App Store and Enterprise profiles
+	ProvisionedDevices    []string
+	ProvisionsAllDevices  bool
+	DeveloperCertificates [][]byte
+}
+
+// NewProfileFromFile decodes the provisioning profile at the given path
+func NewProfileFromFile(pth string) (Profile, error) {
+	content, err := ioutil.ReadFile(pth)
+	if err != nil {
+		return Profile{}, fmt.Errorf("Failed to read provisioning profile: %s\n%v", pth, err)
+	}
+
+	profile, err := NewProfileFromContent(content)
+	if err != nil {
+		return Profile{}, fmt.Errorf("Failed to decode provisioning profile: %s\n%v", pth, err)
+	}
+
+	return profile, nil
+}
+
+// NewProfileFromContent decodes the PKCS#7 signed provisioning profile content
+func NewProfileFromContent(content []byte) (Profile, error) {
+	p7, err := pkcs7.Parse(content)
+	if err != nil {
+		return Profile{}, fmt.Errorf("failed to parse PKCS#7 envelope: %v", err)
+	}
+
+	var data profileutil.PlistData
+	if _, err := plist.Unmarshal(p7.Content, &data); err != nil {
+		return Profile{}, fmt.Errorf("failed to parse profile plist: %v", err)
+	}
+
+	platforms, _ := plistutil.PlistData(data).GetStringArray("Platform")
+
+	teamID := data.GetTeamID()
+	if teamID == "" {
+		if teamIDs, ok := plistutil.PlistData(data).GetStringArray("TeamIdentifier"); ok && len(teamIDs) > 0 {
+			teamID = teamIDs[0]
+		}
+	}
+
+	provisionedDevices := data.GetProvisionedDevices()
+	if _, ok := data["ProvisionedDevices"]; ok && provisionedDevices == nil {
+		provisionedDevices = []string{}
+	}
+
+	return Profile{
+		Name:                  data.GetName(),
+		UUID:                  data.GetUUID(),
+		TeamID:                teamID,
+		TeamName:              data.GetTeamName(),
+		Platforms:             platforms,
+		Entitlements:          data.GetEntitlements(),
+		CreationDate:          data.GetCreationDate(),
+		ExpirationDate:        data.GetExpirationDate(),
+		ProvisionedDevices:    provisionedDevices,
+		ProvisionsAllDevices:  data.GetProvisionsAllDevices(),
+		DeveloperCertificates: data.GetDeveloperCertificates(),
+	}, nil
+}
+
+// HasPlatform reports whether the profile is valid for the given platform, like iOS or OSX
+func (p Profile) HasPlatform(platform string) bool {
+	for _, p := range p.Platforms {
+		if p == platform {
+			return true
+		}
+	}
+	return false
+}
+
+// HasProvisionedDevices reports whether the profile is a development or ad-hoc profile, limited to a list of devices
+func (p Profile) HasProvisionedDevices() bool {
+	return p.ProvisionedDevices != nil
+}
+
+// IsDevelopment reports whether the profile allows debugging (get-task-allow entitlement)
+func (p Profile) IsDevelopment() bool {
+	getTaskAllow, _ := p.Entitlements.GetBool("get-task-allow")
+	return getTaskAllow
+}
+
+// Certificates returns the parsed developer certificates of the profile
+func (p Profile) Certificates() ([]*x509.Certificate, error) {
+	var certificates []*x509.Certificate
+	for _, data := range p.DeveloperCertificates {
+		certificate, err := x509.ParseCertificate(data)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse developer certificate of provisioning profile %s (%s)\n%v", p.Name, p.UUID, err)
+		}
+		certificates = append(certificates, certificate)
+	}
+	return certificates, nil
+}
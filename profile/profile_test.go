@@ -0,0 +1,130 @@
+package profile
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewProfileFromFile(t *testing.T) {
+	creationDate := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	expirationDate := time.Date(2027, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name                      string
+		file                      string
+		wantName                  string
+		wantUUID                  string
+		wantPlatforms             []string
+		wantProvisionedDevices    []string
+		wantHasProvisionedDevices bool
+		wantProvisionsAllDevices  bool
+		wantIsDevelopment         bool
+	}{
+		{
+			name:                      "development",
+			file:                      "development.mobileprovision",
+			wantName:                  "Sample Development",
+			wantUUID:                  "11111111-1111-1111-1111-111111111111",
+			wantPlatforms:             []string{"iOS"},
+			wantProvisionedDevices:    []string{"00008030-001A2B3C4D5E6F70", "0123456789abcdef0123456789abcdef01234567"},
+			wantHasProvisionedDevices: true,
+			wantIsDevelopment:         true,
+		},
+		{
+			name:                      "ad-hoc without devices",
+			file:                      "adhoc_no_devices.mobileprovision",
+			wantName:                  "Sample AdHoc",
+			wantUUID:                  "22222222-2222-2222-2222-222222222222",
+			wantPlatforms:             []string{"iOS"},
+			wantProvisionedDevices:    []string{},
+			wantHasProvisionedDevices: true,
+		},
+		{
+			name:          "App Store",
+			file:          "appstore.mobileprovision",
+			wantName:      "Sample App Store",
+			wantUUID:      "33333333-3333-3333-3333-333333333333",
+			wantPlatforms: []string{"iOS", "xrOS"},
+		},
+		{
+			name:                     "Developer ID",
+			file:                     "developer_id.provisionprofile",
+			wantName:                 "Sample Developer ID",
+			wantUUID:                 "44444444-4444-4444-4444-444444444444",
+			wantPlatforms:            []string{"OSX"},
+			wantProvisionsAllDevices: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			profile, err := NewProfileFromFile(filepath.Join("testdata", tt.file))
+			if err != nil {
+				t.Fatalf("NewProfileFromFile() error = %v", err)
+			}
+
+			if profile.Name != tt.wantName {
+				t.Errorf("Name = %s, want %s", profile.Name, tt.wantName)
+			}
+			if profile.UUID != tt.wantUUID {
+				t.Errorf("UUID = %s, want %s", profile.UUID, tt.wantUUID)
+			}
+			if profile.TeamID != "ABCDE12345" {
+				t.Errorf("TeamID = %s, want ABCDE12345", profile.TeamID)
+			}
+			if profile.TeamName != "Bitrise Test" {
+				t.Errorf("TeamName = %s, want Bitrise Test", profile.TeamName)
+			}
+			if !reflect.DeepEqual(profile.Platforms, tt.wantPlatforms) {
+				t.Errorf("Platforms = %v, want %v", profile.Platforms, tt.wantPlatforms)
+			}
+			// nil and empty provisioned devices are different: nil means an App Store, Enterprise or Developer ID profile
+			if !reflect.DeepEqual(profile.ProvisionedDevices, tt.wantProvisionedDevices) {
+				t.Errorf("ProvisionedDevices = %#v, want %#v", profile.ProvisionedDevices, tt.wantProvisionedDevices)
+			}
+			if got := profile.HasProvisionedDevices(); got != tt.wantHasProvisionedDevices {
+				t.Errorf("HasProvisionedDevices() = %v, want %v", got, tt.wantHasProvisionedDevices)
+			}
+			if profile.ProvisionsAllDevices != tt.wantProvisionsAllDevices {
+				t.Errorf("ProvisionsAllDevices = %v, want %v", profile.ProvisionsAllDevices, tt.wantProvisionsAllDevices)
+			}
+			if !profile.CreationDate.Equal(creationDate) {
+				t.Errorf("CreationDate = %v, want %v", profile.CreationDate, creationDate)
+			}
+			if !profile.ExpirationDate.Equal(expirationDate) {
+				t.Errorf("ExpirationDate = %v, want %v", profile.ExpirationDate, expirationDate)
+			}
+			if got := profile.IsDevelopment(); got != tt.wantIsDevelopment {
+				t.Errorf("IsDevelopment() = %v, want %v", got, tt.wantIsDevelopment)
+			}
+			for _, platform := range tt.wantPlatforms {
+				if !profile.HasPlatform(platform) {
+					t.Errorf("HasPlatform(%s) = false, want true", platform)
+				}
+			}
+			if profile.HasPlatform("tvOS") {
+				t.Errorf("HasPlatform(tvOS) = true, want false")
+			}
+
+			certificates, err := profile.Certificates()
+			if err != nil {
+				t.Fatalf("Certificates() error = %v", err)
+			}
+			if len(certificates) != 1 || certificates[0].Subject.CommonName != "Test Signer" {
+				t.Errorf("Certificates() = %v, want the Test Signer certificate", certificates)
+			}
+		})
+	}
+}
+
+func TestNewProfileFromFile_Errors(t *testing.T) {
+	if _, err := NewProfileFromFile(filepath.Join("testdata", "missing.mobileprovision")); err == nil || !strings.Contains(err.Error(), "Failed to read provisioning profile") {
+		t.Errorf("NewProfileFromFile() error = %v, want read error", err)
+	}
+
+	if _, err := NewProfileFromFile(filepath.Join("testdata", "invalid.mobileprovision")); err == nil || !strings.Contains(err.Error(), "failed to parse PKCS#7 envelope") {
+		t.Errorf("NewProfileFromFile() error = %v, want PKCS#7 error", err)
+	}
+}
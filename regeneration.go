@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/appstoreconnect"
+)
+
+// ProfileRegeneration holds everything needed to recreate a profile, resolved before the profile is deleted
+type ProfileRegeneration struct {
+	Profile        appstoreconnect.Profile
+	BundleID       appstoreconnect.BundleID
+	CertificateIDs []string
+	DeviceIDs      []string
+}
+
+// NewProfileRegeneration resolves the bundle ID and the certificates of the profile, without changing anything on the Developer Portal
+func NewProfileRegeneration(client *appstoreconnect.Client, profile *appstoreconnect.Profile, deviceIDs []string) (*ProfileRegeneration, error) {
+	// BundleID
+	bundleID, err := GetBundleID(client, profile)
+	if err != nil {
+		return nil, err
+	}
+
+	// Certificates
+	certificateIDs, err := GetCertificates(client, profile)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ProfileRegeneration{
+		Profile:        *profile,
+		BundleID:       *bundleID,
+		CertificateIDs: certificateIDs,
+		DeviceIDs:      deviceIDs,
+	}, nil
+}
+
+// Execute deletes the profile and creates it again with the same name, type, bundle ID and certificates, including the devices.
+// The original profile is snapshotted to the journal before the deletion, and restored if the creation fails.
+func (r ProfileRegeneration) Execute(client *appstoreconnect.Client, journal *Journal) (*appstoreconnect.Profile, error) {
+	return journal.Regenerate(client, r)
+}
+
+// isNotFound reports whether the Developer Portal responded with 404 Not Found
+func isNotFound(err error) bool {
+	rerr, ok := err.(*appstoreconnect.ErrorResponse)
+	return ok && rerr.Response != nil && rerr.Response.StatusCode == http.StatusNotFound
+}
+
+// DeleteProfile deletes the profile, deleting an already deleted profile succeeds.
+// autoprovision.DeleteProfile does not recognize the *appstoreconnect.ErrorResponse of a missing profile.
+func DeleteProfile(client *appstoreconnect.Client, id string) error {
+	if err := client.Provisioning.DeleteProfile(id); err != nil && !isNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// profileExists reports whether the profile is still on the Developer Portal
+func profileExists(client *appstoreconnect.Client, id string) (bool, error) {
+	req, err := client.NewRequest(http.MethodGet, appstoreconnect.ProfilesEndpoint+"/"+id, nil)
+	if err != nil {
+		return false, err
+	}
+
+	if _, err := client.Do(req, nil); err != nil {
+		if isNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
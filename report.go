@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/birmacher/steps-register-ios-device/device"
+	"github.com/bitrise-io/go-steputils/tools"
+	"github.com/bitrise-io/go-utils/log"
+	"github.com/bitrise-io/go-xcode/exportoptions"
+	"github.com/bitrise-steplib/steps-ios-auto-provision-appstoreconnect/appstoreconnect"
+)
+
+// Profile report actions
+const (
+	ProfileUnchanged   = "unchanged"
+	ProfileRegenerated = "regenerated"
+	ProfileCreated     = "created"
+)
+
+// PhaseTiming is the duration of a phase of the run
+type PhaseTiming struct {
+	Name    string  `json:"name"`
+	Seconds float64 `json:"seconds"`
+}
+
+// ProfileReport describes the installed profile of an archive bundle, or a profile regenerated in decommission mode
+type ProfileReport struct {
+	BundleID string `json:"bundle_id"`
+	Name     string `json:"name"`
+	// OldUUID is the UUID of the profile embedded in the archive, or of the original profile in decommission mode
+	OldUUID        string                      `json:"old_uuid"`
+	NewUUID        string                      `json:"new_uuid"`
+	ProfileType    appstoreconnect.ProfileType `json:"profile_type"`
+	Action         string                      `json:"action"`
+	DeviceCount    int                         `json:"device_count"`
+	CertificateIDs []string                    `json:"certificate_ids"`
+	// InstalledPath is empty for the profiles regenerated in decommission mode, which are not installed
+	InstalledPath  string    `json:"installed_path"`
+	ExpirationDate time.Time `json:"expiration_date"`
+}
+
+// Report is the machine readable summary of the run
+type Report struct {
+	path       string
+	phase      string
+	phaseStart time.Time
+
+	Mode            string        `json:"mode"`
+	DryRun          bool          `json:"dry_run"`
+	StartedAt       time.Time     `json:"started_at"`
+	FinishedAt      time.Time     `json:"finished_at"`
+	DurationSeconds float64       `json:"duration_seconds"`
+	Timings         []PhaseTiming `json:"timings"`
+	// Devices are the registered devices, or the disabled devices in decommission mode
+	Devices           []device.Result      `json:"devices"`
+	Profiles          []ProfileReport      `json:"profiles"`
+	ExportMethod      exportoptions.Method `json:"export_method,omitempty"`
+	ExportOptionsPath string               `json:"export_options_path,omitempty"`
+	Plan              *Plan                `json:"dry_run_plan,omitempty"`
+	Error             string               `json:"error,omitempty"`
+}
+
+// runReport is written when the step exits
+var runReport = &Report{StartedAt: time.Now()}
+
+// reportPath returns the path to write the report to
+func reportPath(config Config) string {
+	if config.ReportPath != "" {
+		return config.ReportPath
+	}
+	if config.DeployDir == "" {
+		return ""
+	}
+	return filepath.Join(config.DeployDir, "register_device_report.json")
+}
+
+// StartPhase finishes the current phase and starts timing the next one
+func (r *Report) StartPhase(name string) {
+	r.endPhase()
+	r.phase = name
+	r.phaseStart = time.Now()
+}
+
+func (r *Report) endPhase() {
+	if r.phase == "" {
+		return
+	}
+	r.Timings = append(r.Timings, PhaseTiming{Name: r.phase, Seconds: time.Since(r.phaseStart).Seconds()})
+	r.phase = ""
+}
+
+// Write finishes the report, writes it to its path and exports the path
+func (r *Report) Write() error {
+	if r.path == "" {
+		return nil
+	}
+
+	r.endPhase()
+	r.FinishedAt = time.Now()
+	r.DurationSeconds = r.FinishedAt.Sub(r.StartedAt).Seconds()
+
+	content, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("Failed to serialize run report\n%v", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(r.path), 0755); err != nil {
+		return fmt.Errorf("Failed to create directory for run report: %s\n%v", r.path, err)
+	}
+	if err := ioutil.WriteFile(r.path, content, 0644); err != nil {
+		return fmt.Errorf("Failed to write run report: %s\n%v", r.path, err)
+	}
+
+	if err := tools.ExportEnvironmentWithEnvman("BITRISE_REGISTER_DEVICE_REPORT_PATH", r.path); err != nil {
+		return fmt.Errorf("Failed to export BITRISE_REGISTER_DEVICE_REPORT_PATH\n%v", err)
+	}
+	return nil
+}
+
+// NewProfileReport describes the installed profile, the devices and certificates are listed from the Developer Portal
+func NewProfileReport(client *appstoreconnect.Client, bundleID, oldUUID, action string, profile appstoreconnect.Profile, installedPath string) (ProfileReport, error) {
+	devices, err := GetDevices(client, &profile)
+	if err != nil {
+		return ProfileReport{}, err
+	}
+
+	certificates, err := ListProfileCertificates(client, &profile)
+	if err != nil {
+		return ProfileReport{}, err
+	}
+	var certificateIDs []string
+	for _, certificate := range certificates {
+		certificateIDs = append(certificateIDs, certificate.ID)
+	}
+
+	return ProfileReport{
+		BundleID:       bundleID,
+		Name:           profile.Attributes.Name,
+		OldUUID:        oldUUID,
+		NewUUID:        profile.Attributes.UUID,
+		ProfileType:    profile.Attributes.ProfileType,
+		Action:         action,
+		DeviceCount:    len(devices),
+		CertificateIDs: certificateIDs,
+		InstalledPath:  installedPath,
+		ExpirationDate: time.Time(profile.Attributes.ExpirationDate),
+	}, nil
+}
+
+// exitWithReport writes the run report and exits successfully
+func exitWithReport() {
+	if err := runReport.Write(); err != nil {
+		logErrorAndExitIfAny(err)
+	}
+	os.Exit(0)
+}
+
+func writeFailureReport(err error) {
+	runReport.Error = err.Error()
+	if werr := runReport.Write(); werr != nil {
+		log.Warnf("%v", werr)
+	}
+}
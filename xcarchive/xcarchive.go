@@ -0,0 +1,298 @@
+package xcarchive
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/birmacher/steps-register-ios-device/profile"
+	"howett.net/plist"
+)
+
+// Kind ...
+type Kind string
+
+// Kinds ...
+const (
+	Application      Kind = "application"
+	AppExtension     Kind = "app-extension"
+	WatchApplication Kind = "watch-application"
+	AppClip          Kind = "app-clip"
+	LoginItem        Kind = "login-item"
+	SystemExtension  Kind = "system-extension"
+	// Other is a bundle with an embedded provisioning profile outside of the known bundle locations
+	Other Kind = "other"
+)
+
+// childBundles are the locations of the bundles embedded in a bundle, relative to its Contents directory on macOS
+var childBundles = []struct {
+	kind    Kind
+	pattern string
+}{
+	{kind: AppExtension, pattern: "PlugIns/*.appex"},
+	// ExtensionKit extensions
+	{kind: AppExtension, pattern: "Extensions/*.appex"},
+	{kind: WatchApplication, pattern: "Watch/*.app"},
+	{kind: AppClip, pattern: "AppClips/*.app"},
+	{kind: LoginItem, pattern: "Library/LoginItems/*.app"},
+	{kind: SystemExtension, pattern: "Library/SystemExtensions/*.systemextension"},
+}
+
+// ApplicationProperties are the properties of the archived application, stored in the archive's Info.plist
+type ApplicationProperties struct {
+	ApplicationPath    string `plist:"ApplicationPath"`
+	CFBundleIdentifier string `plist:"CFBundleIdentifier"`
+	SigningIdentity    string `plist:"SigningIdentity"`
+	Team               string `plist:"Team"`
+}
+
+// Info is the content of the archive's Info.plist
+type Info struct {
+	Name                  string                `plist:"Name"`
+	SchemeName            string                `plist:"SchemeName"`
+	ApplicationProperties ApplicationProperties `plist:"ApplicationProperties"`
+}
+
+// Bundle is an application, app extension, watch application, App Clip, login item or system extension in the archive
+type Bundle struct {
+	Kind Kind
+	// Path is the path of the bundle, macOS bundles keep their content in its Contents directory
+	Path       string
+	BundleID   string
+	Executable string
+	// DisplayName is the CFBundleDisplayName, or the CFBundleName if the display name is not set
+	DisplayName string
+	// Version is the CFBundleShortVersionString
+	Version string
+	// BuildNumber is the CFBundleVersion
+	BuildNumber string
+	// ProfilePath is the path of the embedded provisioning profile, empty if the bundle has no embedded profile
+	ProfilePath string
+	Profile     *profile.Profile
+	// Children are the bundles embedded in this bundle, like app extensions or watch applications
+	Children []Bundle
+}
+
+// Archive is a parsed Xcode archive
+type Archive struct {
+	Path string
+	Info Info
+	// Application is the main application of the archive
+	Application Bundle
+}
+
+type bundleInfo struct {
+	CFBundleIdentifier         string `plist:"CFBundleIdentifier"`
+	CFBundleExecutable         string `plist:"CFBundleExecutable"`
+	CFBundleName               string `plist:"CFBundleName"`
+	CFBundleDisplayName        string `plist:"CFBundleDisplayName"`
+	CFBundleShortVersionString string `plist:"CFBundleShortVersionString"`
+	CFBundleVersion            string `plist:"CFBundleVersion"`
+}
+
+// NewArchive parses the Xcode archive at the given path
+func NewArchive(pth string) (Archive, error) {
+	info := Info{}
+	infoPlistPath := filepath.Join(pth, "Info.plist")
+	if err := readPlist(infoPlistPath, &info); err != nil {
+		return Archive{}, fmt.Errorf("Failed to read Xcarchive's Info.plist file at path: %s\n%v", infoPlistPath, err)
+	}
+
+	if info.ApplicationProperties.ApplicationPath == "" {
+		return Archive{}, fmt.Errorf("Xcarchive's Info.plist file does not contain ApplicationProperties:ApplicationPath: %s", infoPlistPath)
+	}
+
+	application, err := newBundle(Application, filepath.Join(pth, "Products", info.ApplicationProperties.ApplicationPath))
+	if err != nil {
+		return Archive{}, err
+	}
+
+	others, err := otherBundles(filepath.Join(pth, "Products"), application)
+	if err != nil {
+		return Archive{}, err
+	}
+	application.Children = append(application.Children, others...)
+
+	return Archive{
+		Path:        pth,
+		Info:        info,
+		Application: application,
+	}, nil
+}
+
+// TeamID returns the development team of the archive
+func (a Archive) TeamID() string {
+	return a.Info.ApplicationProperties.Team
+}
+
+// SigningIdentity returns the signing identity the archive was signed with
+func (a Archive) SigningIdentity() string {
+	return a.Info.ApplicationProperties.SigningIdentity
+}
+
+// MainBundleID returns the bundle ID of the main application, as recorded in ApplicationProperties:CFBundleIdentifier
+func (a Archive) MainBundleID() string {
+	if a.Info.ApplicationProperties.CFBundleIdentifier != "" {
+		return a.Info.ApplicationProperties.CFBundleIdentifier
+	}
+	return a.Application.BundleID
+}
+
+// Bundles returns every bundle of the archive, the main application first, followed by its children depth-first
+func (a Archive) Bundles() []Bundle {
+	return flatten(a.Application)
+}
+
+// BundleIDs returns the bundle IDs of every bundle of the archive
+func (a Archive) BundleIDs() []string {
+	var bundleIDs []string
+	for _, bundle := range a.Bundles() {
+		bundleIDs = append(bundleIDs, bundle.BundleID)
+	}
+	return bundleIDs
+}
+
+func flatten(bundle Bundle) []Bundle {
+	bundles := []Bundle{bundle}
+	for _, child := range bundle.Children {
+		bundles = append(bundles, flatten(child)...)
+	}
+	return bundles
+}
+
+// contentsDir returns the directory holding the bundle's Info.plist:
+// the Contents directory of macOS bundles, the bundle itself for iOS and tvOS bundles
+func contentsDir(pth string) string {
+	contentsPath := filepath.Join(pth, "Contents")
+	if _, err := os.Stat(filepath.Join(contentsPath, "Info.plist")); err == nil {
+		return contentsPath
+	}
+	return pth
+}
+
+func newBundle(kind Kind, pth string) (Bundle, error) {
+	contentsPath := contentsDir(pth)
+	isMacOS := contentsPath != pth
+
+	info := bundleInfo{}
+	infoPlistPath := filepath.Join(contentsPath, "Info.plist")
+	if err := readPlist(infoPlistPath, &info); err != nil {
+		return Bundle{}, fmt.Errorf("Failed to read Info.plist file at path: %s\n%v", infoPlistPath, err)
+	}
+
+	bundle := Bundle{
+		Kind:        kind,
+		Path:        pth,
+		BundleID:    info.CFBundleIdentifier,
+		Executable:  info.CFBundleExecutable,
+		DisplayName: info.CFBundleDisplayName,
+		Version:     info.CFBundleShortVersionString,
+		BuildNumber: info.CFBundleVersion,
+	}
+	if bundle.DisplayName == "" {
+		bundle.DisplayName = info.CFBundleName
+	}
+
+	profilePath := filepath.Join(contentsPath, "embedded.mobileprovision")
+	if isMacOS {
+		profilePath = filepath.Join(contentsPath, "embedded.provisionprofile")
+	}
+	if _, err := os.Stat(profilePath); err == nil {
+		embeddedProfile, err := profile.NewProfileFromFile(profilePath)
+		if err != nil {
+			return Bundle{}, err
+		}
+		bundle.ProfilePath = profilePath
+		bundle.Profile = &embeddedProfile
+	} else if !os.IsNotExist(err) {
+		return Bundle{}, err
+	}
+
+	for _, child := range childBundles {
+		pths, err := filepath.Glob(filepath.Join(contentsPath, child.pattern))
+		if err != nil {
+			return Bundle{}, err
+		}
+		sort.Strings(pths)
+
+		for _, childPath := range pths {
+			childBundle, err := newBundle(child.kind, childPath)
+			if err != nil {
+				return Bundle{}, err
+			}
+			bundle.Children = append(bundle.Children, childBundle)
+		}
+	}
+
+	return bundle, nil
+}
+
+func isEmbeddedProfile(pth string) bool {
+	name := filepath.Base(pth)
+	return name == "embedded.mobileprovision" || name == "embedded.provisionprofile"
+}
+
+// otherBundles walks the products directory for embedded provisioning profiles outside of the application's bundle tree,
+// and returns the bundles holding them, so that no signed bundle is left out
+func otherBundles(productsPath string, application Bundle) ([]Bundle, error) {
+	var profilePaths []string
+	if err := filepath.Walk(productsPath, func(pth string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && isEmbeddedProfile(pth) {
+			profilePaths = append(profilePaths, pth)
+		}
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("Failed to search for provisioning profiles in: %s\n%v", productsPath, err)
+	}
+
+	// Outer bundles first, their own embedded bundles are found while parsing them
+	sort.Strings(profilePaths)
+	sort.SliceStable(profilePaths, func(i, j int) bool {
+		return len(profilePaths[i]) < len(profilePaths[j])
+	})
+
+	found := map[string]bool{}
+	addFound := func(bundles []Bundle) {
+		for _, bundle := range bundles {
+			if bundle.ProfilePath != "" {
+				found[bundle.ProfilePath] = true
+			}
+		}
+	}
+	addFound(flatten(application))
+
+	var others []Bundle
+	for _, profilePath := range profilePaths {
+		if found[profilePath] {
+			continue
+		}
+
+		bundlePath := filepath.Dir(profilePath)
+		if filepath.Base(bundlePath) == "Contents" {
+			bundlePath = filepath.Dir(bundlePath)
+		}
+
+		bundle, err := newBundle(Other, bundlePath)
+		if err != nil {
+			return nil, err
+		}
+		addFound(flatten(bundle))
+		others = append(others, bundle)
+	}
+	return others, nil
+}
+
+func readPlist(pth string, v interface{}) error {
+	content, err := ioutil.ReadFile(pth)
+	if err != nil {
+		return err
+	}
+
+	_, err = plist.Unmarshal(content, v)
+	return err
+}
@@ -0,0 +1,198 @@
+package xcarchive
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNewArchive(t *testing.T) {
+	archive, err := NewArchive(filepath.Join("testdata", "ios.xcarchive"))
+	if err != nil {
+		t.Fatalf("NewArchive() error = %v", err)
+	}
+
+	if got, want := archive.TeamID(), "ABCDE12345"; got != want {
+		t.Errorf("TeamID() = %s, want %s", got, want)
+	}
+	if got, want := archive.SigningIdentity(), "Apple Development: Bitrise Test (ABCDE12345)"; got != want {
+		t.Errorf("SigningIdentity() = %s, want %s", got, want)
+	}
+
+	application := archive.Application
+	if application.Kind != Application {
+		t.Errorf("Application.Kind = %s, want %s", application.Kind, Application)
+	}
+	if got, want := application.Path, filepath.Join("testdata", "ios.xcarchive", "Products", "Applications", "Sample.app"); got != want {
+		t.Errorf("Application.Path = %s, want %s", got, want)
+	}
+	if application.DisplayName != "Sample App" || application.Version != "1.2.3" || application.BuildNumber != "42" || application.Executable != "Sample" {
+		t.Errorf("Application = %+v, want display name, version, build number and executable from the Info.plist", application)
+	}
+	if application.Profile == nil {
+		t.Fatalf("Application.Profile = nil, want the embedded profile")
+	}
+	if got, want := application.Profile.UUID, "11111111-1111-1111-1111-111111111111"; got != want {
+		t.Errorf("Application.Profile.UUID = %s, want %s", got, want)
+	}
+	if got, want := application.ProfilePath, filepath.Join(application.Path, "embedded.mobileprovision"); got != want {
+		t.Errorf("Application.ProfilePath = %s, want %s", got, want)
+	}
+}
+
+func TestNewArchive_MacOS(t *testing.T) {
+	archive, err := NewArchive(filepath.Join("testdata", "macos.xcarchive"))
+	if err != nil {
+		t.Fatalf("NewArchive() error = %v", err)
+	}
+
+	application := archive.Application
+	if got, want := application.ProfilePath, filepath.Join(application.Path, "Contents", "embedded.provisionprofile"); got != want {
+		t.Errorf("Application.ProfilePath = %s, want %s", got, want)
+	}
+	if application.Profile == nil || !application.Profile.ProvisionsAllDevices {
+		t.Errorf("Application.Profile = %+v, want the embedded Developer ID profile", application.Profile)
+	}
+}
+
+func TestNewArchive_Errors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "xcarchive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if _, err := NewArchive(dir); err == nil || !strings.Contains(err.Error(), "Failed to read Xcarchive's Info.plist") {
+		t.Errorf("NewArchive() error = %v, want missing Info.plist error", err)
+	}
+
+	content := `<?xml version="1.0" encoding="UTF-8"?>
+<plist version="1.0"><dict><key>ApplicationProperties</key><dict></dict></dict></plist>`
+	if err := ioutil.WriteFile(filepath.Join(dir, "Info.plist"), []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewArchive(dir); err == nil || !strings.Contains(err.Error(), "ApplicationProperties:ApplicationPath") {
+		t.Errorf("NewArchive() error = %v, want missing ApplicationPath error", err)
+	}
+}
+
+type wantBundle struct {
+	kind       Kind
+	bundleID   string
+	hasProfile bool
+}
+
+func TestArchive_Bundles(t *testing.T) {
+	tests := []struct {
+		name    string
+		archive string
+		want    []wantBundle
+	}{
+		{
+			name:    "iOS",
+			archive: "ios.xcarchive",
+			want: []wantBundle{
+				{kind: Application, bundleID: "io.bitrise.sample", hasProfile: true},
+				{kind: AppExtension, bundleID: "io.bitrise.sample.share", hasProfile: true},
+				{kind: AppExtension, bundleID: "io.bitrise.sample.widget", hasProfile: false},
+				{kind: AppExtension, bundleID: "io.bitrise.sample.kit", hasProfile: true},
+				{kind: WatchApplication, bundleID: "io.bitrise.sample.watchkitapp", hasProfile: true},
+				{kind: AppExtension, bundleID: "io.bitrise.sample.watchkitapp.watchkitextension", hasProfile: true},
+				{kind: AppClip, bundleID: "io.bitrise.sample.clip", hasProfile: true},
+				{kind: Other, bundleID: "io.bitrise.sample.helper", hasProfile: true},
+			},
+		},
+		{
+			name:    "macOS",
+			archive: "macos.xcarchive",
+			want: []wantBundle{
+				{kind: Application, bundleID: "io.bitrise.mac", hasProfile: true},
+				{kind: LoginItem, bundleID: "io.bitrise.mac.launcher", hasProfile: true},
+				{kind: SystemExtension, bundleID: "io.bitrise.mac.filter", hasProfile: true},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			archive, err := NewArchive(filepath.Join("testdata", tt.archive))
+			if err != nil {
+				t.Fatalf("NewArchive() error = %v", err)
+			}
+
+			bundles := archive.Bundles()
+			if len(bundles) != len(tt.want) {
+				t.Fatalf("Bundles() = %d bundles (%v), want %d", len(bundles), archive.BundleIDs(), len(tt.want))
+			}
+			for i, want := range tt.want {
+				bundle := bundles[i]
+				if bundle.Kind != want.kind || bundle.BundleID != want.bundleID || (bundle.Profile != nil) != want.hasProfile {
+					t.Errorf("Bundles()[%d] = %s %s (profile: %v), want %s %s (profile: %v)", i, bundle.Kind, bundle.BundleID, bundle.Profile != nil, want.kind, want.bundleID, want.hasProfile)
+				}
+				if (bundle.ProfilePath != "") != want.hasProfile {
+					t.Errorf("Bundles()[%d].ProfilePath = %s, want profile: %v", i, bundle.ProfilePath, want.hasProfile)
+				}
+			}
+		})
+	}
+}
+
+func TestArchive_BundleIDs(t *testing.T) {
+	archive, err := NewArchive(filepath.Join("testdata", "ios.xcarchive"))
+	if err != nil {
+		t.Fatalf("NewArchive() error = %v", err)
+	}
+
+	want := []string{
+		"io.bitrise.sample",
+		"io.bitrise.sample.share",
+		"io.bitrise.sample.widget",
+		"io.bitrise.sample.kit",
+		"io.bitrise.sample.watchkitapp",
+		"io.bitrise.sample.watchkitapp.watchkitextension",
+		"io.bitrise.sample.clip",
+		"io.bitrise.sample.helper",
+	}
+	if got := archive.BundleIDs(); !reflect.DeepEqual(got, want) {
+		t.Errorf("BundleIDs() = %v, want %v", got, want)
+	}
+}
+
+func TestArchive_MainBundleID(t *testing.T) {
+	tests := []struct {
+		name    string
+		archive Archive
+		want    string
+	}{
+		{
+			name: "from ApplicationProperties",
+			archive: Archive{
+				Info:        Info{ApplicationProperties: ApplicationProperties{CFBundleIdentifier: "io.bitrise.sample"}},
+				Application: Bundle{BundleID: "io.bitrise.other"},
+			},
+			want: "io.bitrise.sample",
+		},
+		{
+			name:    "falls back to the application bundle",
+			archive: Archive{Application: Bundle{BundleID: "io.bitrise.sample"}},
+			want:    "io.bitrise.sample",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.archive.MainBundleID(); got != tt.want {
+				t.Errorf("MainBundleID() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	archive, err := NewArchive(filepath.Join("testdata", "macos.xcarchive"))
+	if err != nil {
+		t.Fatalf("NewArchive() error = %v", err)
+	}
+	if got, want := archive.MainBundleID(), "io.bitrise.mac"; got != want {
+		t.Errorf("MainBundleID() = %s, want %s", got, want)
+	}
+}